@@ -17,18 +17,26 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
 	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
@@ -55,6 +63,23 @@ func main() {
 		enableLeaderElection bool
 		probeAddr            string
 		watchNamespaces      string
+		resyncPeriod         time.Duration
+		backendName          string
+		eksClusterName       string
+		filePath             string
+		configMapNamespace   string
+		configMapName        string
+		controllerClass      string
+		dryRun               bool
+		historyNamespace     string
+		historySize          int
+		orphanPolicy         string
+		orphanSweepPeriod    time.Duration
+		adminGroups          string
+		minAdminMappings     int
+		allowNodeRemoval     bool
+		protectedArnsFlag    string
+		generateNamespace    string
 	)
 
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
@@ -64,6 +89,44 @@ func main() {
 			"Enabling this will ensure there is only one active controller manager.")
 	flag.StringVar(&watchNamespaces, "watch-namespaces", "",
 		"The namespaces to watch, comma-separated. Default: watch all namespaces")
+	flag.DurationVar(&resyncPeriod, "resync-period", 10*time.Minute,
+		"The interval of full resyncs of the aws-auth ConfigMap. Set to 0 to disable periodic resyncs")
+	flag.StringVar(&backendName, "backend", controllers.BACKEND_CONFIGMAP,
+		"Where to store the mappings: configmap (the aws-auth ConfigMap), accessentries (EKS access entries), "+
+			"iamidentitymappings (aws-iam-authenticator CRD mode), file (aws-iam-authenticator config file) "+
+			"or memory (for testing only). Several backends can be given, separated by commas, to write to all of them")
+	flag.StringVar(&eksClusterName, "eks-cluster-name", "",
+		"The name of the EKS cluster, required by the accessentries backend")
+	flag.StringVar(&filePath, "file-path", "",
+		"The path of the aws-iam-authenticator config file, required by the file backend")
+	flag.StringVar(&configMapNamespace, "configmap-namespace", controllers.CONFIG_MAP_NAMESPACE,
+		"The namespace of the ConfigMap used by the configmap backend")
+	flag.StringVar(&configMapName, "configmap-name", controllers.CONFIG_MAP_NAME,
+		"The name of the ConfigMap used by the configmap backend")
+	flag.StringVar(&controllerClass, "controller-class", "",
+		"Only handle snippets whose spec.controllerClass equals this value. Default: handle snippets without a class")
+	flag.BoolVar(&dryRun, "dry-run", false,
+		"Only report the changes of the mappings in logs, events and the snippet status instead of writing them")
+	flag.StringVar(&historyNamespace, "history-namespace", os.Getenv("POD_NAMESPACE"),
+		"The namespace of the snapshots of the ConfigMap. Default: the namespace of the controller")
+	flag.IntVar(&historySize, "history-size", 10,
+		"The number of snapshots of the ConfigMap to keep. Set to 0 to disable snapshots")
+	flag.StringVar(&orphanPolicy, "orphan-policy", controllers.ORPHAN_POLICY_REPORT,
+		"What to do with managed entries of the ConfigMap whose snippet does not exist anymore: delete or report")
+	flag.DurationVar(&orphanSweepPeriod, "orphan-sweep-period", 10*time.Minute,
+		"The interval of the search for orphaned entries. Set to 0 to only search at startup")
+	flag.StringVar(&adminGroups, "admin-groups", "system:masters",
+		"The groups that grant administrative access, comma-separated. Used by the lock-out protection")
+	flag.IntVar(&minAdminMappings, "min-admin-mappings", 1,
+		"Refuse writes of the ConfigMap that leave fewer mappings with an admin group. Set to 0 to disable")
+	flag.BoolVar(&allowNodeRemoval, "allow-node-mapping-removal", false,
+		"Allow writes of the ConfigMap that remove mappings of the system:bootstrappers or system:nodes groups")
+	flag.StringVar(&protectedArnsFlag, "protected-arns", "",
+		"ARNs and account IDs whose entries are never modified or removed, comma-separated. "+
+			"Glob patterns like arn:aws:iam::111122223333:role/break-glass-* are supported")
+	flag.StringVar(&generateNamespace, "generate-snippets-namespace", "",
+		"Generate an adopting snippet in this namespace for every unmanaged entry of the ConfigMap at startup. "+
+			"Default: do not generate snippets")
 
 	opts := zap.Options{
 		Development: true,
@@ -73,6 +136,25 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	if orphanPolicy != controllers.ORPHAN_POLICY_DELETE && orphanPolicy != controllers.ORPHAN_POLICY_REPORT {
+		setupLog.Error(nil, "invalid orphan policy, must be delete or report", "policy", orphanPolicy)
+		os.Exit(1)
+	}
+	namespaces := strings.Split(watchNamespaces, ",")
+	if generateNamespace != "" && watchNamespaces != "" && !containsString(namespaces, generateNamespace) {
+		setupLog.Error(nil, "the namespace of generated snippets must be watched",
+			"namespace", generateNamespace, "watchNamespaces", watchNamespaces)
+		os.Exit(1)
+	}
+	var protectedArns controllers.ProtectedArns
+	if protectedArnsFlag != "" {
+		protectedArns = strings.Split(protectedArnsFlag, ",")
+	}
+	if err := protectedArns.Validate(); err != nil {
+		setupLog.Error(err, "invalid protected ARNs")
+		os.Exit(1)
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
@@ -80,22 +162,100 @@ func main() {
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "91595be7.awsauth.io",
+		// Only the aws-auth ConfigMap is watched, so do not cache all others.
+		NewCache: cache.BuilderWithOptions(cache.Options{
+			SelectorsByObject: cache.SelectorsByObject{
+				&corev1.ConfigMap{}: {
+					Field: fields.SelectorFromSet(fields.Set{
+						"metadata.namespace": configMapNamespace,
+						"metadata.name":      configMapName,
+					}),
+				},
+			},
+		}),
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
-	if err = (&controllers.AwsAuthMapSnippetReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+	backendOptions := controllers.BackendOptions{
+		Client:       mgr.GetClient(),
+		Reader:       mgr.GetAPIReader(),
+		ConfigMapKey: client.ObjectKey{Namespace: configMapNamespace, Name: configMapName},
+		ClusterName:  eksClusterName,
+		FilePath:     filePath,
+		Protection: &controllers.LockoutProtection{
+			AdminGroups:      strings.Split(adminGroups, ","),
+			MinAdminMappings: minAdminMappings,
+			AllowNodeRemoval: allowNodeRemoval,
+		},
+	}
+	if historySize > 0 && historyNamespace != "" {
+		backendOptions.History = &controllers.History{
+			Client:    mgr.GetClient(),
+			Reader:    mgr.GetAPIReader(),
+			Namespace: historyNamespace,
+			Size:      historySize,
+		}
+	}
+	if controllers.UsesBackend(backendName, controllers.BACKEND_ACCESS_ENTRIES) {
+		awsConfig, err := awsconfig.LoadDefaultConfig(context.Background())
+		if err != nil {
+			setupLog.Error(err, "unable to load AWS config")
+			os.Exit(1)
+		}
+		backendOptions.EKSClient = eks.NewFromConfig(awsConfig)
+	}
+	backend, err := controllers.NewBackend(backendName, backendOptions)
+	if err != nil {
+		setupLog.Error(err, "unable to create backend")
+		os.Exit(1)
+	}
+
+	snippetReconciler := &controllers.AwsAuthMapSnippetReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("aws-auth-controller"),
+		Backend:  backend,
 		Options: controllers.AwsAuthMapSnippetReconcilerOptions{
-			Namespaces: strings.Split(watchNamespaces, ","),
+			Namespaces:        namespaces,
+			ResyncPeriod:      resyncPeriod,
+			ControllerClass:   controllerClass,
+			DryRun:            dryRun,
+			OrphanPolicy:      orphanPolicy,
+			OrphanSweepPeriod: orphanSweepPeriod,
+			ProtectedArns:     protectedArns,
+
+			GenerateSnippetsNamespace: generateNamespace,
 		},
-	}).SetupWithManager(mgr); err != nil {
+	}
+	if err = snippetReconciler.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "AwsAuthMapSnippet")
 		os.Exit(1)
 	}
+	if err = (&controllers.ClusterAwsAuthMapSnippetReconciler{
+		AwsAuthMapSnippetReconciler: snippetReconciler,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ClusterAwsAuthMapSnippet")
+		os.Exit(1)
+	}
+	if err = (&controllers.AwsAuthMapRestoreReconciler{
+		AwsAuthMapSnippetReconciler: snippetReconciler,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "AwsAuthMapRestore")
+		os.Exit(1)
+	}
+	if os.Getenv("ENABLE_WEBHOOKS") == "true" {
+		if err = (&crdv1beta1.AwsAuthMapSnippet{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "AwsAuthMapSnippet")
+			os.Exit(1)
+		}
+		if err = (&crdv1beta1.ClusterAwsAuthMapSnippet{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "ClusterAwsAuthMapSnippet")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -113,3 +273,12 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
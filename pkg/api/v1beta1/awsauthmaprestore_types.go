@@ -0,0 +1,106 @@
+/*
+Copyright 2023 inovex GmbH
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AwsAuthMapRestoreSpec selects the snapshot to restore.
+type AwsAuthMapRestoreSpec struct {
+	// Snapshot is the name of the history ConfigMap in the namespace of the
+	// controller whose mapRoles and mapUsers are restored.
+	//+kubebuilder:validation:MinLength=1
+	Snapshot string `json:"snapshot"`
+
+	// Resume ends the pause of the snippet reconciliation. The snippets are
+	// applied again, which replaces the restored entries they manage.
+	//+optional
+	Resume bool `json:"resume,omitempty"`
+}
+
+// AwsAuthMapRestoreStatus defines the observed state of AwsAuthMapRestore.
+type AwsAuthMapRestoreStatus struct {
+	// RestoredSnapshot is the name of the snapshot that was restored last.
+	//+optional
+	RestoredSnapshot string `json:"restoredSnapshot,omitempty"`
+	// RestoreTime is the time the snapshot was restored.
+	//+optional
+	RestoreTime *metav1.Time `json:"restoreTime,omitempty"`
+
+	// Conditions describe the current state of the restore.
+	//+optional
+	//+listType=map
+	//+listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+const (
+	// ConditionRestored is true if the snapshot was written to the
+	// ConfigMap.
+	ConditionRestored = "Restored"
+	// ConditionPaused is true as long as the restore pauses the snippet
+	// reconciliation.
+	ConditionPaused = "Paused"
+
+	// ReasonSnapshotRestored is used if the snapshot was written.
+	ReasonSnapshotRestored = "SnapshotRestored"
+	// ReasonRestoreFailed is used if the snapshot could not be read or
+	// written.
+	ReasonRestoreFailed = "RestoreFailed"
+	// ReasonPausedByRestore is used while snippets are not reconciled.
+	ReasonPausedByRestore = "PausedByRestore"
+	// ReasonResumed is used once the operator resumed the reconciliation.
+	ReasonResumed = "Resumed"
+)
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Snapshot",type=string,JSONPath=`.spec.snapshot`
+//+kubebuilder:printcolumn:name="Restored",type=string,JSONPath=`.status.conditions[?(@.type=="Restored")].status`
+//+kubebuilder:printcolumn:name="Paused",type=string,JSONPath=`.status.conditions[?(@.type=="Paused")].status`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// AwsAuthMapRestore is the Schema for the awsauthmaprestores API. It rolls
+// the mappings back to a snapshot and pauses the reconciliation of snippets
+// until it is resumed or deleted.
+type AwsAuthMapRestore struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AwsAuthMapRestoreSpec   `json:"spec,omitempty"`
+	Status AwsAuthMapRestoreStatus `json:"status,omitempty"`
+}
+
+// Pauses returns true if the restore pauses the snippet reconciliation.
+func (r *AwsAuthMapRestore) Pauses() bool {
+	return !r.Spec.Resume && r.DeletionTimestamp.IsZero()
+}
+
+//+kubebuilder:object:root=true
+
+// AwsAuthMapRestoreList contains a list of AwsAuthMapRestore
+type AwsAuthMapRestoreList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []AwsAuthMapRestore `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&AwsAuthMapRestore{}, &AwsAuthMapRestoreList{})
+}
@@ -39,10 +39,27 @@ type MapUsersSpec struct {
 	Groups   []string `json:"groups"`
 }
 
-// AwsAuthMapSnippetSpec defines the IAM role and user mappings to RBAC.
+//+kubebuilder:validation:Pattern="^[0-9]{12}$"
+
+// AccountID is the 12-digit ID of an AWS account. All IAM users and roles of
+// the account are mapped to RBAC users named after their ARN.
+type AccountID string
+
+//+kubebuilder:validation:XValidation:rule="has(self.controllerClass) == has(oldSelf.controllerClass) && (!has(self.controllerClass) || self.controllerClass == oldSelf.controllerClass)",message="controllerClass is immutable"
+
+// AwsAuthMapSnippetSpec defines the IAM role, user and account mappings to RBAC.
 type AwsAuthMapSnippetSpec struct {
-	MapRoles []MapRolesSpec `json:"mapRoles,omitempty"`
-	MapUsers []MapUsersSpec `json:"mapUsers,omitempty"`
+	MapRoles    []MapRolesSpec `json:"mapRoles,omitempty"`
+	MapUsers    []MapUsersSpec `json:"mapUsers,omitempty"`
+	MapAccounts []AccountID    `json:"mapAccounts,omitempty"`
+
+	// ControllerClass selects the controller that handles the snippet. A
+	// controller only handles snippets whose class equals its
+	// --controller-class flag, the default controller those without a class.
+	// It cannot be changed, as the previous controller would never remove the
+	// mappings of the snippet.
+	//+optional
+	ControllerClass string `json:"controllerClass,omitempty"`
 }
 
 // AwsAuthMapSnippetStatus defines the observed state of AwsAuthMapSnippet.
@@ -52,12 +69,116 @@ type AwsAuthMapSnippetStatus struct {
 
 	RoleArns []string `json:"roleArns,omitempty"`
 	UserArns []string `json:"userArns,omitempty"`
-	IsSynced bool     `json:"isSynced,omitempty"`
+	Accounts []string `json:"accounts,omitempty"`
+
+	// ObservedGeneration is the generation of the spec that was last
+	// reconciled.
+	//+optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// LastSyncTime is the time the mappings were last written successfully.
+	//+optional
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+	// LastError is the message of the error of the last failed sync.
+	//+optional
+	LastError string `json:"lastError,omitempty"`
+	// Backends reports the outcome of the last sync for every backend the
+	// mappings are written to.
+	//+optional
+	Backends []BackendStatus `json:"backends,omitempty"`
+	// DryRunDiff is the unified diff of mapRoles and mapUsers that the
+	// snippet would cause if it was not in dry-run mode.
+	//+optional
+	DryRunDiff string `json:"dryRunDiff,omitempty"`
+
+	// PendingRestores lists the ARNs and account IDs of the snippet that
+	// replaced an existing entry. The original entries are put back once
+	// the snippet is deleted or stops declaring them.
+	//+optional
+	PendingRestores []string `json:"pendingRestores,omitempty"`
+
+	// Conditions describe the current state of the snippet.
+	//+optional
+	//+listType=map
+	//+listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
+// BackendStatus is the outcome of the last sync of a single backend.
+type BackendStatus struct {
+	Name   string `json:"name"`
+	Synced bool   `json:"synced"`
+	//+optional
+	LastError string `json:"lastError,omitempty"`
+}
+
+const (
+	// ConditionReady is true if the snippet is valid, synced and all of its
+	// ARNs are applied to all backends.
+	ConditionReady = "Ready"
+	// ConditionSynced is true if the last write of the mappings succeeded.
+	ConditionSynced = "Synced"
+	// ConditionConflict is true if an ARN of the snippet is also declared by
+	// another snippet that takes precedence.
+	ConditionConflict = "Conflict"
+	// ConditionInvalid is true if the spec contains mistakes that are not
+	// caught by the CRD schema. Invalid snippets keep the mappings they
+	// wrote before as they are.
+	ConditionInvalid = "Invalid"
+	// ConditionDiverged is true if the mappings of the snippet differ between
+	// the backends. It is only set if more than one backend is used.
+	ConditionDiverged = "Diverged"
+	// ConditionBlocked is true if changes of the mappings of the snippet were
+	// refused because they would lock administrators or nodes out of the
+	// cluster.
+	ConditionBlocked = "Blocked"
+	// ConditionRejected is true if the snippet declares protected ARNs or
+	// account IDs, or entries that a backend does not support. They are not
+	// applied.
+	ConditionRejected = "Rejected"
+
+	// ReasonReconciled is used if the snippet is ready.
+	ReasonReconciled = "Reconciled"
+	// ReasonSyncSucceeded is used if the mappings were written.
+	ReasonSyncSucceeded = "SyncSucceeded"
+	// ReasonSyncFailed is used if the mappings could not be written.
+	ReasonSyncFailed = "SyncFailed"
+	// ReasonDryRun is used if the mappings were not written because of the
+	// dry-run mode.
+	ReasonDryRun = "DryRun"
+	// ReasonArnClaimed is used if an ARN is claimed by another snippet.
+	ReasonArnClaimed = "ArnClaimed"
+	// ReasonNoConflict is used if all ARNs of the snippet are applied.
+	ReasonNoConflict = "NoConflict"
+	// ReasonValidationFailed is used if the spec is invalid.
+	ReasonValidationFailed = "ValidationFailed"
+	// ReasonValid is used if the spec is valid.
+	ReasonValid = "Valid"
+	// ReasonBackendsDiverged is used if a mapping differs between backends.
+	ReasonBackendsDiverged = "BackendsDiverged"
+	// ReasonBackendsConsistent is used if all backends agree.
+	ReasonBackendsConsistent = "BackendsConsistent"
+	// ReasonLockoutProtection is used if a write was refused by the lock-out
+	// protection.
+	ReasonLockoutProtection = "LockoutProtection"
+	// ReasonNotBlocked is used if the last write was not refused.
+	ReasonNotBlocked = "NotBlocked"
+	// ReasonProtectedArn is used if the snippet declares a protected ARN.
+	ReasonProtectedArn = "ProtectedArn"
+	// ReasonNoProtectedArn is used if the snippet declares no protected ARN
+	// and all of its entries are supported.
+	ReasonNoProtectedArn = "NoProtectedArn"
+	// ReasonUnsupportedEntry is used if a backend does not write an entry of
+	// the snippet.
+	ReasonUnsupportedEntry = "UnsupportedEntry"
+)
+
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
-//+kubebuilder:printcolumn:name="Synced",type=boolean,JSONPath=`.status.isSynced`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Synced",type=string,JSONPath=`.status.conditions[?(@.type=="Synced")].status`
+//+kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].reason`,priority=1
+//+kubebuilder:printcolumn:name="Last Sync",type=date,JSONPath=`.status.lastSyncTime`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // AwsAuthMapSnippet is the Schema for the awsauthmapsnippets API
 type AwsAuthMapSnippet struct {
@@ -68,6 +189,16 @@ type AwsAuthMapSnippet struct {
 	Status AwsAuthMapSnippetStatus `json:"status,omitempty"`
 }
 
+// GetSpec returns the mappings declared by the snippet.
+func (s *AwsAuthMapSnippet) GetSpec() *AwsAuthMapSnippetSpec {
+	return &s.Spec
+}
+
+// GetStatus returns the status of the snippet.
+func (s *AwsAuthMapSnippet) GetStatus() *AwsAuthMapSnippetStatus {
+	return &s.Status
+}
+
 //+kubebuilder:object:root=true
 
 // AwsAuthMapSnippetList contains a list of AwsAuthMapSnippet
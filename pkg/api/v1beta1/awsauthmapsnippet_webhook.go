@@ -0,0 +1,184 @@
+/*
+Copyright 2023 inovex GmbH
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"regexp"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var awsauthmapsnippetlog = logf.Log.WithName("awsauthmapsnippet-resource")
+
+// usernameTemplates are the placeholders that aws-iam-authenticator expands
+// in usernames.
+var usernameTemplates = []string{
+	"AccessKeyID",
+	"AccountID",
+	"EC2InstanceID",
+	"EC2PrivateDNSName",
+	"SessionName",
+	"SessionNameRaw",
+}
+
+var templateRegexp = regexp.MustCompile(`{{([^{}]*)}}`)
+
+func (r *AwsAuthMapSnippet) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-crd-awsauth-io-v1beta1-awsauthmapsnippet,mutating=false,failurePolicy=fail,sideEffects=None,groups=crd.awsauth.io,resources=awsauthmapsnippets,verbs=create;update,versions=v1beta1,name=vawsauthmapsnippet.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &AwsAuthMapSnippet{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *AwsAuthMapSnippet) ValidateCreate() error {
+	awsauthmapsnippetlog.Info("validate create", "name", r.Name)
+	return r.validate()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *AwsAuthMapSnippet) ValidateUpdate(old runtime.Object) error {
+	awsauthmapsnippetlog.Info("validate update", "name", r.Name)
+	errs := r.Spec.Validate(field.NewPath("spec"))
+	if oldSnippet, ok := old.(*AwsAuthMapSnippet); ok {
+		errs = append(errs, r.Spec.ValidateUpdate(&oldSnippet.Spec, field.NewPath("spec"))...)
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("AwsAuthMapSnippet").GroupKind(), r.Name, errs)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *AwsAuthMapSnippet) ValidateDelete() error {
+	// Snippets can always be deleted.
+	return nil
+}
+
+func (r *AwsAuthMapSnippet) validate() error {
+	errs := r.Spec.Validate(field.NewPath("spec"))
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("AwsAuthMapSnippet").GroupKind(), r.Name, errs)
+}
+
+/*
+Validate checks the spec for mistakes that are not caught by the CRD schema.
+
+These are empty usernames and groups, ARNs or account IDs that are declared
+more than once and malformed username templates.
+*/
+func (s *AwsAuthMapSnippetSpec) Validate(path *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+
+	roleArns := map[string]bool{}
+	for i, mr := range s.MapRoles {
+		p := path.Child("mapRoles").Index(i)
+		if roleArns[mr.RoleArn] {
+			errs = append(errs, field.Duplicate(p.Child("rolearn"), mr.RoleArn))
+		}
+		roleArns[mr.RoleArn] = true
+		errs = append(errs, validateUserName(p.Child("username"), mr.UserName)...)
+		errs = append(errs, validateGroups(p.Child("groups"), mr.Groups)...)
+	}
+
+	userArns := map[string]bool{}
+	for i, mu := range s.MapUsers {
+		p := path.Child("mapUsers").Index(i)
+		if userArns[mu.UserArn] {
+			errs = append(errs, field.Duplicate(p.Child("userarn"), mu.UserArn))
+		}
+		userArns[mu.UserArn] = true
+		errs = append(errs, validateUserName(p.Child("username"), mu.UserName)...)
+		errs = append(errs, validateGroups(p.Child("groups"), mu.Groups)...)
+	}
+
+	accounts := map[AccountID]bool{}
+	for i, ma := range s.MapAccounts {
+		if accounts[ma] {
+			errs = append(errs, field.Duplicate(path.Child("mapAccounts").Index(i), ma))
+		}
+		accounts[ma] = true
+	}
+	return errs
+}
+
+/*
+ValidateUpdate checks the changes of the spec against the old one. The
+controller class is immutable, as the controller of the old class would never
+remove the mappings of the snippet.
+*/
+func (s *AwsAuthMapSnippetSpec) ValidateUpdate(old *AwsAuthMapSnippetSpec, path *field.Path) field.ErrorList {
+	if s.ControllerClass != old.ControllerClass {
+		return field.ErrorList{field.Forbidden(path.Child("controllerClass"), "controllerClass is immutable")}
+	}
+	return nil
+}
+
+func validateUserName(path *field.Path, userName string) field.ErrorList {
+	if strings.TrimSpace(userName) == "" {
+		return field.ErrorList{field.Required(path, "username must not be empty")}
+	}
+
+	errs := field.ErrorList{}
+	for _, match := range templateRegexp.FindAllStringSubmatch(userName, -1) {
+		if !containsString(usernameTemplates, match[1]) {
+			errs = append(errs, field.Invalid(path, userName,
+				"unknown template {{"+match[1]+"}}, supported are "+strings.Join(usernameTemplates, ", ")))
+		}
+	}
+	// Anything left over after removing the templates is an unbalanced brace.
+	rest := templateRegexp.ReplaceAllString(userName, "")
+	if strings.ContainsAny(rest, "{}") {
+		errs = append(errs, field.Invalid(path, userName, "malformed template, expected {{Name}}"))
+	}
+	return errs
+}
+
+func validateGroups(path *field.Path, groups []string) field.ErrorList {
+	if len(groups) == 0 {
+		return field.ErrorList{field.Required(path, "at least one group is required")}
+	}
+
+	errs := field.ErrorList{}
+	for i, group := range groups {
+		if strings.TrimSpace(group) == "" {
+			errs = append(errs, field.Required(path.Index(i), "group must not be empty"))
+		}
+	}
+	return errs
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package v1beta1
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// These tests use Ginkgo (BDD-style Go testing framework). Refer to
+// http://onsi.github.io/ginkgo/ to learn more about Ginkgo.
+
+func TestWebhook(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "Webhook Suite")
+}
+
+const (
+	ROLE_ARN = "arn:aws:iam::111122223333:role/node"
+	USER_ARN = "arn:aws:iam::111122223333:user/admin"
+)
+
+var _ = Context("Webhook", func() {
+	Describe("ValidateCreate", func() {
+		DescribeTable("validate snippets", func(spec AwsAuthMapSnippetSpec, field string) {
+			snippet := &AwsAuthMapSnippet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "foo-bar",
+					Namespace: "foo",
+				},
+				Spec: spec,
+			}
+			err := snippet.ValidateCreate()
+			if field == "" {
+				Expect(err).ToNot(HaveOccurred())
+			} else {
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring(field))
+			}
+		},
+			Entry("with valid mappings", AwsAuthMapSnippetSpec{
+				MapRoles: []MapRolesSpec{
+					{RoleArn: ROLE_ARN, UserName: "system:node:{{EC2PrivateDNSName}}", Groups: []string{"system:nodes"}},
+				},
+				MapUsers: []MapUsersSpec{
+					{UserArn: USER_ARN, UserName: "admin", Groups: []string{"system:masters"}},
+				},
+				MapAccounts: []AccountID{"111122223333"},
+			}, ""),
+			Entry("with empty username", AwsAuthMapSnippetSpec{
+				MapUsers: []MapUsersSpec{
+					{UserArn: USER_ARN, UserName: " ", Groups: []string{"system:masters"}},
+				},
+			}, "spec.mapUsers[0].username"),
+			Entry("with no groups", AwsAuthMapSnippetSpec{
+				MapRoles: []MapRolesSpec{
+					{RoleArn: ROLE_ARN, UserName: "node", Groups: []string{}},
+				},
+			}, "spec.mapRoles[0].groups"),
+			Entry("with empty group", AwsAuthMapSnippetSpec{
+				MapRoles: []MapRolesSpec{
+					{RoleArn: ROLE_ARN, UserName: "node", Groups: []string{"system:nodes", ""}},
+				},
+			}, "spec.mapRoles[0].groups[1]"),
+			Entry("with duplicate role ARN", AwsAuthMapSnippetSpec{
+				MapRoles: []MapRolesSpec{
+					{RoleArn: ROLE_ARN, UserName: "node", Groups: []string{"system:nodes"}},
+					{RoleArn: ROLE_ARN, UserName: "other", Groups: []string{"system:nodes"}},
+				},
+			}, "spec.mapRoles[1].rolearn"),
+			Entry("with duplicate user ARN", AwsAuthMapSnippetSpec{
+				MapUsers: []MapUsersSpec{
+					{UserArn: USER_ARN, UserName: "admin", Groups: []string{"system:masters"}},
+					{UserArn: USER_ARN, UserName: "admin", Groups: []string{"system:masters"}},
+				},
+			}, "spec.mapUsers[1].userarn"),
+			Entry("with duplicate account", AwsAuthMapSnippetSpec{
+				MapAccounts: []AccountID{"111122223333", "111122223333"},
+			}, "spec.mapAccounts[1]"),
+			Entry("with unknown template", AwsAuthMapSnippetSpec{
+				MapRoles: []MapRolesSpec{
+					{RoleArn: ROLE_ARN, UserName: "system:node:{{PrivateDNSName}}", Groups: []string{"system:nodes"}},
+				},
+			}, "unknown template"),
+			Entry("with unbalanced template", AwsAuthMapSnippetSpec{
+				MapRoles: []MapRolesSpec{
+					{RoleArn: ROLE_ARN, UserName: "system:node:{{EC2PrivateDNSName}", Groups: []string{"system:nodes"}},
+				},
+			}, "malformed template"),
+		)
+	})
+
+	Describe("ValidateUpdate", func() {
+		snippetOfClass := func(class string) *AwsAuthMapSnippet {
+			return &AwsAuthMapSnippet{
+				ObjectMeta: metav1.ObjectMeta{Name: "foo-bar", Namespace: "foo"},
+				Spec: AwsAuthMapSnippetSpec{
+					MapRoles: []MapRolesSpec{
+						{RoleArn: ROLE_ARN, UserName: "node", Groups: []string{"system:nodes"}},
+					},
+					ControllerClass: class,
+				},
+			}
+		}
+
+		It("should allow changes of the mappings", func() {
+			updated := snippetOfClass("internal")
+			updated.Spec.MapRoles[0].UserName = "other"
+			Expect(updated.ValidateUpdate(snippetOfClass("internal"))).To(Succeed())
+		})
+
+		It("should refuse to change the controller class", func() {
+			err := snippetOfClass("internal").ValidateUpdate(snippetOfClass(""))
+			Expect(err).To(MatchError(ContainSubstring("spec.controllerClass")))
+			Expect(snippetOfClass("").ValidateUpdate(snippetOfClass("internal"))).ToNot(Succeed())
+		})
+	})
+
+	Describe("ClusterAwsAuthMapSnippet", func() {
+		clusterSnippet := func(userName, class string) *ClusterAwsAuthMapSnippet {
+			return &ClusterAwsAuthMapSnippet{
+				ObjectMeta: metav1.ObjectMeta{Name: "foo-bar"},
+				Spec: AwsAuthMapSnippetSpec{
+					MapRoles: []MapRolesSpec{
+						{RoleArn: ROLE_ARN, UserName: userName, Groups: []string{"system:nodes"}},
+					},
+					ControllerClass: class,
+				},
+			}
+		}
+
+		It("should validate the spec like namespaced snippets", func() {
+			Expect(clusterSnippet("node", "").ValidateCreate()).To(Succeed())
+			err := clusterSnippet(" ", "").ValidateCreate()
+			Expect(err).To(MatchError(ContainSubstring("spec.mapRoles[0].username")))
+			Expect(err).To(MatchError(ContainSubstring("ClusterAwsAuthMapSnippet")))
+		})
+
+		It("should refuse to change the controller class", func() {
+			Expect(clusterSnippet("other", "").ValidateUpdate(clusterSnippet("node", ""))).To(Succeed())
+			err := clusterSnippet("node", "internal").ValidateUpdate(clusterSnippet("node", ""))
+			Expect(err).To(MatchError(ContainSubstring("spec.controllerClass")))
+		})
+	})
+})
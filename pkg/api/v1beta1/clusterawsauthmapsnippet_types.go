@@ -0,0 +1,63 @@
+/*
+Copyright 2023 inovex GmbH
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Synced",type=string,JSONPath=`.status.conditions[?(@.type=="Synced")].status`
+//+kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].reason`,priority=1
+//+kubebuilder:printcolumn:name="Last Sync",type=date,JSONPath=`.status.lastSyncTime`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ClusterAwsAuthMapSnippet is the Schema for the clusterawsauthmapsnippets API.
+// It holds platform-level mappings that don't belong to any namespace.
+type ClusterAwsAuthMapSnippet struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AwsAuthMapSnippetSpec   `json:"spec,omitempty"`
+	Status AwsAuthMapSnippetStatus `json:"status,omitempty"`
+}
+
+// GetSpec returns the mappings declared by the snippet.
+func (s *ClusterAwsAuthMapSnippet) GetSpec() *AwsAuthMapSnippetSpec {
+	return &s.Spec
+}
+
+// GetStatus returns the status of the snippet.
+func (s *ClusterAwsAuthMapSnippet) GetStatus() *AwsAuthMapSnippetStatus {
+	return &s.Status
+}
+
+//+kubebuilder:object:root=true
+
+// ClusterAwsAuthMapSnippetList contains a list of ClusterAwsAuthMapSnippet
+type ClusterAwsAuthMapSnippetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterAwsAuthMapSnippet `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterAwsAuthMapSnippet{}, &ClusterAwsAuthMapSnippetList{})
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2023 inovex GmbH
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var clusterawsauthmapsnippetlog = logf.Log.WithName("clusterawsauthmapsnippet-resource")
+
+func (r *ClusterAwsAuthMapSnippet) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-crd-awsauth-io-v1beta1-clusterawsauthmapsnippet,mutating=false,failurePolicy=fail,sideEffects=None,groups=crd.awsauth.io,resources=clusterawsauthmapsnippets,verbs=create;update,versions=v1beta1,name=vclusterawsauthmapsnippet.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &ClusterAwsAuthMapSnippet{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *ClusterAwsAuthMapSnippet) ValidateCreate() error {
+	clusterawsauthmapsnippetlog.Info("validate create", "name", r.Name)
+	return r.invalid(r.Spec.Validate(field.NewPath("spec")))
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *ClusterAwsAuthMapSnippet) ValidateUpdate(old runtime.Object) error {
+	clusterawsauthmapsnippetlog.Info("validate update", "name", r.Name)
+	errs := r.Spec.Validate(field.NewPath("spec"))
+	if oldSnippet, ok := old.(*ClusterAwsAuthMapSnippet); ok {
+		errs = append(errs, r.Spec.ValidateUpdate(&oldSnippet.Spec, field.NewPath("spec"))...)
+	}
+	return r.invalid(errs)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *ClusterAwsAuthMapSnippet) ValidateDelete() error {
+	// Snippets can always be deleted.
+	return nil
+}
+
+func (r *ClusterAwsAuthMapSnippet) invalid(errs field.ErrorList) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("ClusterAwsAuthMapSnippet").GroupKind(), r.Name, errs)
+}
@@ -22,9 +22,110 @@ limitations under the License.
 package v1beta1
 
 import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AwsAuthMapRestore) DeepCopyInto(out *AwsAuthMapRestore) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AwsAuthMapRestore.
+func (in *AwsAuthMapRestore) DeepCopy() *AwsAuthMapRestore {
+	if in == nil {
+		return nil
+	}
+	out := new(AwsAuthMapRestore)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *AwsAuthMapRestore) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AwsAuthMapRestoreList) DeepCopyInto(out *AwsAuthMapRestoreList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]AwsAuthMapRestore, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AwsAuthMapRestoreList.
+func (in *AwsAuthMapRestoreList) DeepCopy() *AwsAuthMapRestoreList {
+	if in == nil {
+		return nil
+	}
+	out := new(AwsAuthMapRestoreList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *AwsAuthMapRestoreList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AwsAuthMapRestoreSpec) DeepCopyInto(out *AwsAuthMapRestoreSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AwsAuthMapRestoreSpec.
+func (in *AwsAuthMapRestoreSpec) DeepCopy() *AwsAuthMapRestoreSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AwsAuthMapRestoreSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AwsAuthMapRestoreStatus) DeepCopyInto(out *AwsAuthMapRestoreStatus) {
+	*out = *in
+	if in.RestoreTime != nil {
+		in, out := &in.RestoreTime, &out.RestoreTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AwsAuthMapRestoreStatus.
+func (in *AwsAuthMapRestoreStatus) DeepCopy() *AwsAuthMapRestoreStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(AwsAuthMapRestoreStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AwsAuthMapSnippet) DeepCopyInto(out *AwsAuthMapSnippet) {
 	*out = *in
@@ -101,6 +202,11 @@ func (in *AwsAuthMapSnippetSpec) DeepCopyInto(out *AwsAuthMapSnippetSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.MapAccounts != nil {
+		in, out := &in.MapAccounts, &out.MapAccounts
+		*out = make([]AccountID, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AwsAuthMapSnippetSpec.
@@ -126,6 +232,32 @@ func (in *AwsAuthMapSnippetStatus) DeepCopyInto(out *AwsAuthMapSnippetStatus) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.Accounts != nil {
+		in, out := &in.Accounts, &out.Accounts
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.LastSyncTime != nil {
+		in, out := &in.LastSyncTime, &out.LastSyncTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Backends != nil {
+		in, out := &in.Backends, &out.Backends
+		*out = make([]BackendStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.PendingRestores != nil {
+		in, out := &in.PendingRestores, &out.PendingRestores
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AwsAuthMapSnippetStatus.
@@ -138,6 +270,80 @@ func (in *AwsAuthMapSnippetStatus) DeepCopy() *AwsAuthMapSnippetStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BackendStatus) DeepCopyInto(out *BackendStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BackendStatus.
+func (in *BackendStatus) DeepCopy() *BackendStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(BackendStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterAwsAuthMapSnippet) DeepCopyInto(out *ClusterAwsAuthMapSnippet) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterAwsAuthMapSnippet.
+func (in *ClusterAwsAuthMapSnippet) DeepCopy() *ClusterAwsAuthMapSnippet {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterAwsAuthMapSnippet)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ClusterAwsAuthMapSnippet) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterAwsAuthMapSnippetList) DeepCopyInto(out *ClusterAwsAuthMapSnippetList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ClusterAwsAuthMapSnippet, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterAwsAuthMapSnippetList.
+func (in *ClusterAwsAuthMapSnippetList) DeepCopy() *ClusterAwsAuthMapSnippetList {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterAwsAuthMapSnippetList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ClusterAwsAuthMapSnippetList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MapRolesSpec) DeepCopyInto(out *MapRolesSpec) {
 	*out = *in
@@ -0,0 +1,333 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	ekstypes "github.com/aws/aws-sdk-go-v2/service/eks/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	crdv1beta1 "github.com/inovex/aws-auth-controller/pkg/api/v1beta1"
+)
+
+// ACCESS_ENTRY_MANAGED_TAG marks access entries that were created by the
+// controller. Only those are ever deleted.
+const ACCESS_ENTRY_MANAGED_TAG = "awsauth.io/managed"
+
+// RESERVED_GROUP_PREFIX starts the Kubernetes groups that EKS rejects in
+// access entries.
+const RESERVED_GROUP_PREFIX = "system:"
+
+// ACCESS_ENTRY_CACHE_TTL is the default time for which described access
+// entries are reused.
+const ACCESS_ENTRY_CACHE_TTL = 5 * time.Minute
+
+// EKSClient is the subset of the EKS API that is used by the
+// AccessEntriesBackend. It is implemented by *eks.Client.
+type EKSClient interface {
+	ListAccessEntries(ctx context.Context, params *eks.ListAccessEntriesInput, optFns ...func(*eks.Options)) (*eks.ListAccessEntriesOutput, error)
+	DescribeAccessEntry(ctx context.Context, params *eks.DescribeAccessEntryInput, optFns ...func(*eks.Options)) (*eks.DescribeAccessEntryOutput, error)
+	CreateAccessEntry(ctx context.Context, params *eks.CreateAccessEntryInput, optFns ...func(*eks.Options)) (*eks.CreateAccessEntryOutput, error)
+	UpdateAccessEntry(ctx context.Context, params *eks.UpdateAccessEntryInput, optFns ...func(*eks.Options)) (*eks.UpdateAccessEntryOutput, error)
+	DeleteAccessEntry(ctx context.Context, params *eks.DeleteAccessEntryInput, optFns ...func(*eks.Options)) (*eks.DeleteAccessEntryOutput, error)
+}
+
+var _ EKSClient = &eks.Client{}
+
+/*
+AccessEntriesBackend stores the mappings as EKS access entries of a cluster.
+
+Every role and user mapping becomes an access entry of type STANDARD with the
+username and the Kubernetes groups of the mapping. Access entries do not
+support account mappings, those are ignored.
+
+Entries created by the controller are tagged with ACCESS_ENTRY_MANAGED_TAG.
+Entries without the tag, e.g. the ones EKS creates for node roles, are never
+modified or deleted, as access entries have no place to keep the original
+entry for its restore. A snippet that declares their ARN gets a Rejected
+condition instead. The same goes for mappings with groups starting with
+RESERVED_GROUP_PREFIX, which EKS rejects. Access policies have to be used for
+those.
+
+Every sync lists the access entries, but only describes the ones that are new
+or whose description is older than CacheTTL. The entries written by the
+backend itself are cached as written, so the number of EKS API calls does not
+grow with the number of snippets. Changes made outside of the controller are
+noticed once the cached description expires.
+*/
+type AccessEntriesBackend struct {
+	Client      EKSClient
+	ClusterName string
+	// CacheTTL is the time for which described access entries are reused,
+	// ACCESS_ENTRY_CACHE_TTL if not set.
+	CacheTTL time.Duration
+
+	cacheMutex sync.Mutex
+	cache      map[string]cachedAccessEntry
+}
+
+// cachedAccessEntry is an access entry together with the time it was
+// described or written.
+type cachedAccessEntry struct {
+	entry ekstypes.AccessEntry
+	at    time.Time
+}
+
+var _ Backend = &AccessEntriesBackend{}
+
+// Name implements Backend.
+func (b *AccessEntriesBackend) Name() string {
+	return BACKEND_ACCESS_ENTRIES
+}
+
+// Load implements Backend.
+func (b *AccessEntriesBackend) Load(ctx context.Context) (*Mappings, error) {
+	mappings, _, err := b.load(ctx)
+	return mappings, err
+}
+
+// load returns the mappings and the ARNs of the entries that were created by
+// the controller.
+func (b *AccessEntriesBackend) load(ctx context.Context) (*Mappings, map[string]bool, error) {
+	mappings := NewMappings()
+	managed := map[string]bool{}
+	listed := map[string]bool{}
+
+	input := &eks.ListAccessEntriesInput{ClusterName: aws.String(b.ClusterName)}
+	for {
+		list, err := b.Client.ListAccessEntries(ctx, input)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, arn := range list.AccessEntries {
+			listed[arn] = true
+			entry, err := b.describe(ctx, arn)
+			if err != nil {
+				var notFound *ekstypes.ResourceNotFoundException
+				if errors.As(err, &notFound) {
+					// Deleted in the meantime
+					continue
+				}
+				return nil, nil, err
+			}
+			if entry.Tags[ACCESS_ENTRY_MANAGED_TAG] == "true" {
+				managed[arn] = true
+			}
+			userName := aws.ToString(entry.Username)
+			if isRoleArn(arn) {
+				mappings.Roles[arn] = crdv1beta1.MapRolesSpec{RoleArn: arn, UserName: userName, Groups: entry.KubernetesGroups}
+			} else {
+				mappings.Users[arn] = crdv1beta1.MapUsersSpec{UserArn: arn, UserName: userName, Groups: entry.KubernetesGroups}
+			}
+		}
+		if list.NextToken == nil {
+			b.forgetUnlisted(listed)
+			return mappings, managed, nil
+		}
+		input.NextToken = list.NextToken
+	}
+}
+
+// describe returns the access entry of the ARN, from the cache if its
+// description has not expired yet.
+func (b *AccessEntriesBackend) describe(ctx context.Context, arn string) (ekstypes.AccessEntry, error) {
+	ttl := b.CacheTTL
+	if ttl == 0 {
+		ttl = ACCESS_ENTRY_CACHE_TTL
+	}
+	b.cacheMutex.Lock()
+	cached, ok := b.cache[arn]
+	b.cacheMutex.Unlock()
+	if ok && time.Since(cached.at) < ttl {
+		return cached.entry, nil
+	}
+
+	out, err := b.Client.DescribeAccessEntry(ctx, &eks.DescribeAccessEntryInput{
+		ClusterName:  aws.String(b.ClusterName),
+		PrincipalArn: aws.String(arn),
+	})
+	if err != nil {
+		b.forget(arn)
+		return ekstypes.AccessEntry{}, err
+	}
+	b.remember(arn, *out.AccessEntry)
+	return *out.AccessEntry, nil
+}
+
+// remember caches the access entry of the ARN.
+func (b *AccessEntriesBackend) remember(arn string, entry ekstypes.AccessEntry) {
+	b.cacheMutex.Lock()
+	defer b.cacheMutex.Unlock()
+	if b.cache == nil {
+		b.cache = map[string]cachedAccessEntry{}
+	}
+	b.cache[arn] = cachedAccessEntry{entry: entry, at: time.Now()}
+}
+
+// forget drops the access entry of the ARN from the cache.
+func (b *AccessEntriesBackend) forget(arn string) {
+	b.cacheMutex.Lock()
+	defer b.cacheMutex.Unlock()
+	delete(b.cache, arn)
+}
+
+// forgetUnlisted drops the access entries that do not exist anymore from the
+// cache.
+func (b *AccessEntriesBackend) forgetUnlisted(listed map[string]bool) {
+	b.cacheMutex.Lock()
+	defer b.cacheMutex.Unlock()
+	for arn := range b.cache {
+		if !listed[arn] {
+			delete(b.cache, arn)
+		}
+	}
+}
+
+// rememberWritten caches an access entry as written by the backend.
+func (b *AccessEntriesBackend) rememberWritten(arn, userName string, groups []string) {
+	b.remember(arn, ekstypes.AccessEntry{
+		ClusterName:      aws.String(b.ClusterName),
+		PrincipalArn:     aws.String(arn),
+		Username:         aws.String(userName),
+		KubernetesGroups: groups,
+		Tags:             map[string]string{ACCESS_ENTRY_MANAGED_TAG: "true"},
+	})
+}
+
+/*
+Apply implements Backend.
+
+It creates, updates and deletes single access entries. If one of the calls
+fails the remaining ones are still made, the failed ones are returned as an
+aggregated error together with the changes that succeeded. The next
+reconciliation retries them.
+*/
+func (b *AccessEntriesBackend) Apply(ctx context.Context, desired *DesiredState) (*Changes, error) {
+	logger := log.FromContext(ctx)
+	if len(desired.Accounts) > 0 {
+		logger.Info("Account mappings are not supported by access entries, ignoring them", "backend", b.Name())
+	}
+
+	current, managed, err := b.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	after := current.Copy()
+	after.Merge(desired)
+	after.Accounts = MapAccountsByID{}
+	skipped := map[string][]string{}
+	for arn, owner := range desired.Owners {
+		_, role := current.Roles[arn]
+		_, user := current.Users[arn]
+		if (role || user) && !managed[arn] {
+			after.revert(current, arn)
+			skipped[owner] = append(skipped[owner], fmt.Sprintf("access entry of %s was not created by the controller", arn))
+			continue
+		}
+		if _, groups := mappingOf(after, arn); len(reservedGroups(groups)) > 0 {
+			after.revert(current, arn)
+			skipped[owner] = append(skipped[owner], fmt.Sprintf("groups %s of %s are reserved by EKS",
+				strings.Join(reservedGroups(groups), ", "), arn))
+		}
+	}
+	for owner := range skipped {
+		sort.Strings(skipped[owner])
+	}
+	diff := diffMappings(current, after)
+
+	changes := &Changes{Adopted: diff.Adopted, Skipped: skipped, Mappings: after}
+	errs := []error{}
+	failed := func(arn string, err error) {
+		// The entry is described again by the next sync.
+		b.forget(arn)
+		after.revert(current, arn)
+		errs = append(errs, err)
+	}
+	for _, arn := range diff.Added {
+		userName, groups := mappingOf(after, arn)
+		_, err := b.Client.CreateAccessEntry(ctx, &eks.CreateAccessEntryInput{
+			ClusterName:      aws.String(b.ClusterName),
+			PrincipalArn:     aws.String(arn),
+			Type:             aws.String("STANDARD"),
+			Username:         aws.String(userName),
+			KubernetesGroups: groups,
+			Tags:             map[string]string{ACCESS_ENTRY_MANAGED_TAG: "true"},
+		})
+		if err != nil {
+			failed(arn, fmt.Errorf("creating access entry for %s: %w", arn, err))
+			continue
+		}
+		b.rememberWritten(arn, userName, groups)
+		changes.Added = append(changes.Added, arn)
+	}
+	for _, arn := range diff.Updated {
+		userName, groups := mappingOf(after, arn)
+		_, err := b.Client.UpdateAccessEntry(ctx, &eks.UpdateAccessEntryInput{
+			ClusterName:      aws.String(b.ClusterName),
+			PrincipalArn:     aws.String(arn),
+			Username:         aws.String(userName),
+			KubernetesGroups: groups,
+		})
+		if err != nil {
+			failed(arn, fmt.Errorf("updating access entry for %s: %w", arn, err))
+			continue
+		}
+		b.rememberWritten(arn, userName, groups)
+		changes.Updated = append(changes.Updated, arn)
+	}
+	for _, arn := range diff.Removed {
+		if !managed[arn] {
+			logger.Info("Not deleting access entry that was not created by the controller", "arn", arn)
+			continue
+		}
+		_, err := b.Client.DeleteAccessEntry(ctx, &eks.DeleteAccessEntryInput{
+			ClusterName:  aws.String(b.ClusterName),
+			PrincipalArn: aws.String(arn),
+		})
+		if err != nil {
+			var notFound *ekstypes.ResourceNotFoundException
+			if !errors.As(err, &notFound) {
+				failed(arn, fmt.Errorf("deleting access entry for %s: %w", arn, err))
+				continue
+			}
+		}
+		b.forget(arn)
+		changes.Removed = append(changes.Removed, arn)
+	}
+	return changes, utilerrors.NewAggregate(errs)
+}
+
+// reservedGroups returns the groups that start with RESERVED_GROUP_PREFIX.
+func reservedGroups(groups []string) []string {
+	reserved := []string{}
+	for _, group := range groups {
+		if strings.HasPrefix(group, RESERVED_GROUP_PREFIX) {
+			reserved = append(reserved, group)
+		}
+	}
+	return reserved
+}
+
+// isRoleArn returns true if the ARN denotes an IAM role, including assumed
+// roles.
+func isRoleArn(arn string) bool {
+	return strings.Contains(arn, ":role/") || strings.Contains(arn, ":assumed-role/")
+}
+
+// mappingOf returns the username and groups of the role or user mapping with
+// the given ARN.
+func mappingOf(mappings *Mappings, arn string) (string, []string) {
+	if mr, ok := mappings.Roles[arn]; ok {
+		return mr.UserName, mr.Groups
+	}
+	mu := mappings.Users[arn]
+	return mu.UserName, mu.Groups
+}
@@ -0,0 +1,229 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	ekstypes "github.com/aws/aws-sdk-go-v2/service/eks/types"
+	crdv1beta1 "github.com/inovex/aws-auth-controller/pkg/api/v1beta1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// FakeEKSClient keeps access entries of a single cluster in memory. Calls
+// for the principal ARNs in Failing return the given error. Describes counts
+// the calls of DescribeAccessEntry.
+type FakeEKSClient struct {
+	mutex     sync.Mutex
+	Entries   map[string]ekstypes.AccessEntry
+	Failing   map[string]error
+	Describes int
+}
+
+var _ EKSClient = &FakeEKSClient{}
+
+func NewFakeEKSClient() *FakeEKSClient {
+	return &FakeEKSClient{Entries: map[string]ekstypes.AccessEntry{}, Failing: map[string]error{}}
+}
+
+func (f *FakeEKSClient) ListAccessEntries(ctx context.Context, params *eks.ListAccessEntriesInput, optFns ...func(*eks.Options)) (*eks.ListAccessEntriesOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	out := &eks.ListAccessEntriesOutput{}
+	for arn := range f.Entries {
+		out.AccessEntries = append(out.AccessEntries, arn)
+	}
+	return out, nil
+}
+
+func (f *FakeEKSClient) DescribeAccessEntry(ctx context.Context, params *eks.DescribeAccessEntryInput, optFns ...func(*eks.Options)) (*eks.DescribeAccessEntryOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.Describes++
+	entry, ok := f.Entries[aws.ToString(params.PrincipalArn)]
+	if !ok {
+		return nil, &ekstypes.ResourceNotFoundException{}
+	}
+	return &eks.DescribeAccessEntryOutput{AccessEntry: &entry}, nil
+}
+
+func (f *FakeEKSClient) CreateAccessEntry(ctx context.Context, params *eks.CreateAccessEntryInput, optFns ...func(*eks.Options)) (*eks.CreateAccessEntryOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	arn := aws.ToString(params.PrincipalArn)
+	if err := f.Failing[arn]; err != nil {
+		return nil, err
+	}
+	if _, ok := f.Entries[arn]; ok {
+		return nil, &ekstypes.ResourceInUseException{}
+	}
+	entry := ekstypes.AccessEntry{
+		ClusterName:      params.ClusterName,
+		PrincipalArn:     params.PrincipalArn,
+		Type:             params.Type,
+		Username:         params.Username,
+		KubernetesGroups: params.KubernetesGroups,
+		Tags:             params.Tags,
+	}
+	f.Entries[arn] = entry
+	return &eks.CreateAccessEntryOutput{AccessEntry: &entry}, nil
+}
+
+func (f *FakeEKSClient) UpdateAccessEntry(ctx context.Context, params *eks.UpdateAccessEntryInput, optFns ...func(*eks.Options)) (*eks.UpdateAccessEntryOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	arn := aws.ToString(params.PrincipalArn)
+	if err := f.Failing[arn]; err != nil {
+		return nil, err
+	}
+	entry, ok := f.Entries[arn]
+	if !ok {
+		return nil, &ekstypes.ResourceNotFoundException{}
+	}
+	entry.Username = params.Username
+	entry.KubernetesGroups = params.KubernetesGroups
+	f.Entries[arn] = entry
+	return &eks.UpdateAccessEntryOutput{AccessEntry: &entry}, nil
+}
+
+func (f *FakeEKSClient) DeleteAccessEntry(ctx context.Context, params *eks.DeleteAccessEntryInput, optFns ...func(*eks.Options)) (*eks.DeleteAccessEntryOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	arn := aws.ToString(params.PrincipalArn)
+	if err := f.Failing[arn]; err != nil {
+		return nil, err
+	}
+	if _, ok := f.Entries[arn]; !ok {
+		return nil, &ekstypes.ResourceNotFoundException{}
+	}
+	delete(f.Entries, arn)
+	return &eks.DeleteAccessEntryOutput{}, nil
+}
+
+var _ = Describe("access entries backend", func() {
+	const (
+		ROLE_ARN = "arn:aws:iam::123456789012:role/access-entry"
+		USER_ARN = "arn:aws:iam::123456789012:user/access-entry"
+		NODE_ARN = "arn:aws:iam::123456789012:role/node"
+	)
+
+	snippet := func(userName string) *crdv1beta1.AwsAuthMapSnippet {
+		return newSnippet("access-entries", userName, ROLE_ARN, USER_ARN)
+	}
+
+	It("should create, update and delete access entries", func() {
+		fake := NewFakeEKSClient()
+		backend := &AccessEntriesBackend{Client: fake, ClusterName: "test"}
+
+		snip := snippet("first")
+		changes, err := backend.Apply(context.Background(), ComputeDesiredState([]Snippet{snip}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes.Added).To(ConsistOf(ROLE_ARN, USER_ARN))
+		Expect(aws.ToString(fake.Entries[ROLE_ARN].Username)).To(Equal("first"))
+		Expect(fake.Entries[ROLE_ARN].KubernetesGroups).To(Equal([]string{"foobar-group"}))
+		Expect(fake.Entries[USER_ARN].Tags).To(HaveKeyWithValue(ACCESS_ENTRY_MANAGED_TAG, "true"))
+
+		snip = snippet("second")
+		changes, err = backend.Apply(context.Background(), ComputeDesiredState([]Snippet{snip}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes.Updated).To(ConsistOf(ROLE_ARN, USER_ARN))
+		Expect(aws.ToString(fake.Entries[USER_ARN].Username)).To(Equal("second"))
+
+		changes, err = backend.Apply(context.Background(), ComputeDesiredState([]Snippet{deleted(snip)}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes.Removed).To(ConsistOf(ROLE_ARN, USER_ARN))
+		Expect(fake.Entries).To(BeEmpty())
+	})
+
+	It("should neither modify nor delete access entries it did not create", func() {
+		fake := NewFakeEKSClient()
+		fake.Entries[NODE_ARN] = ekstypes.AccessEntry{
+			PrincipalArn: aws.String(NODE_ARN),
+			Type:         aws.String("EC2_LINUX"),
+			Username:     aws.String("system:node:{{EC2PrivateDNSName}}"),
+		}
+		backend := &AccessEntriesBackend{Client: fake, ClusterName: "test"}
+
+		snip := newSnippet("node", "node", NODE_ARN)
+		changes, err := backend.Apply(context.Background(), ComputeDesiredState([]Snippet{snip}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes.Empty()).To(BeTrue())
+		Expect(changes.Skipped).To(HaveKeyWithValue("default/node", ConsistOf(ContainSubstring(NODE_ARN))))
+		Expect(aws.ToString(fake.Entries[NODE_ARN].Username)).To(Equal("system:node:{{EC2PrivateDNSName}}"))
+
+		setRejectedCondition(snip, nil, []BackendResult{{Backend: backend.Name(), Changes: changes}})
+		rejected := meta.FindStatusCondition(snip.Status.Conditions, crdv1beta1.ConditionRejected)
+		Expect(rejected.Status).To(Equal(metav1.ConditionTrue))
+		Expect(rejected.Reason).To(Equal(crdv1beta1.ReasonUnsupportedEntry))
+
+		changes, err = backend.Apply(context.Background(), ComputeDesiredState([]Snippet{deleted(snip)}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes.Empty()).To(BeTrue())
+		Expect(fake.Entries).To(HaveKey(NODE_ARN))
+	})
+
+	It("should not send groups reserved by EKS", func() {
+		fake := NewFakeEKSClient()
+		backend := &AccessEntriesBackend{Client: fake, ClusterName: "test"}
+
+		snip := snippet("admin")
+		snip.Spec.MapRoles[0].Groups = []string{"system:masters", "admins"}
+		changes, err := backend.Apply(context.Background(), ComputeDesiredState([]Snippet{snip}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes.Added).To(ConsistOf(USER_ARN))
+		Expect(changes.Skipped).To(HaveKeyWithValue("default/access-entries",
+			ConsistOf(And(ContainSubstring(ROLE_ARN), ContainSubstring("system:masters")))))
+		Expect(fake.Entries).ToNot(HaveKey(ROLE_ARN))
+		Expect(changes.Mappings.Roles).ToNot(HaveKey(ROLE_ARN))
+	})
+
+	It("should apply the remaining entries if one of them fails", func() {
+		fake := NewFakeEKSClient()
+		fake.Failing[ROLE_ARN] = errors.New("throttled")
+		backend := &AccessEntriesBackend{Client: fake, ClusterName: "test"}
+
+		changes, err := backend.Apply(context.Background(), ComputeDesiredState([]Snippet{snippet("first")}))
+		Expect(err).To(MatchError(ContainSubstring(ROLE_ARN)))
+		Expect(changes.Added).To(ConsistOf(USER_ARN))
+		Expect(fake.Entries).To(HaveKey(USER_ARN))
+		Expect(changes.Mappings.Roles).ToNot(HaveKey(ROLE_ARN))
+
+		// The next reconciliation retries the failed entry.
+		delete(fake.Failing, ROLE_ARN)
+		changes, err = backend.Apply(context.Background(), ComputeDesiredState([]Snippet{snippet("first")}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes.Added).To(ConsistOf(ROLE_ARN))
+	})
+
+	It("should only describe new or expired access entries", func() {
+		fake := NewFakeEKSClient()
+		fake.Entries[NODE_ARN] = ekstypes.AccessEntry{PrincipalArn: aws.String(NODE_ARN), Type: aws.String("EC2_LINUX")}
+		backend := &AccessEntriesBackend{Client: fake, ClusterName: "test"}
+
+		_, err := backend.Apply(context.Background(), ComputeDesiredState([]Snippet{snippet("first")}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fake.Describes).To(Equal(1))
+
+		changes, err := backend.Apply(context.Background(), ComputeDesiredState([]Snippet{snippet("second")}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes.Updated).To(ConsistOf(ROLE_ARN, USER_ARN))
+		Expect(fake.Describes).To(Equal(1))
+
+		// Changes made outside of the controller show up once the cache
+		// expires.
+		entry := fake.Entries[ROLE_ARN]
+		entry.Username = aws.String("modified")
+		fake.Entries[ROLE_ARN] = entry
+		backend.CacheTTL = time.Nanosecond
+		changes, err = backend.Apply(context.Background(), ComputeDesiredState([]Snippet{snippet("second")}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes.Updated).To(ConsistOf(ROLE_ARN))
+		Expect(fake.Describes).To(Equal(4))
+	})
+})
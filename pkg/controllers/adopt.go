@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	crdv1beta1 "github.com/inovex/aws-auth-controller/pkg/api/v1beta1"
+)
+
+/*
+ADOPT_ANNOTATION lets a snippet take ownership of existing unmanaged entries
+if set to "true". Entries that equal the declaration of the snippet are not
+rewritten and not restored on deletion, they belong to the snippet from then
+on.
+*/
+const ADOPT_ANNOTATION = "awsauth.io/adopt"
+
+// GENERATED_LABEL marks the snippets created by GenerateSnippets.
+const GENERATED_LABEL = "awsauth.io/generated"
+
+// EventReasonAdopted is recorded on snippets that adopted entries.
+const EventReasonAdopted = "Adopted"
+
+// invalidNameChars are the characters that are replaced in generated names.
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+/*
+GenerateSnippets creates an adopting AwsAuthMapSnippet in the namespace
+Options.GenerateSnippetsNamespace for every unmanaged entry of the ConfigMap,
+so that all entries end up being managed declaratively. It runs once at
+startup. Snippets that exist already are left as they are, so restarting the
+controller does not create duplicates.
+
+Entries that are declared by a snippet, protected or invalid are skipped. An
+invalid snippet would remove its entries instead of adopting them. In dry-run
+mode the snippets are only logged, and nothing is generated while an
+AwsAuthMapRestore pauses the reconciliation.
+*/
+func (r *AwsAuthMapSnippetReconciler) GenerateSnippets(ctx context.Context) error {
+	logger := log.FromContext(ctx)
+	cmb := r.configMapBackend()
+	if cmb == nil {
+		return nil
+	}
+	awsauth := cmb.awsAuthMap()
+	if err := awsauth.Read(ctx); err != nil {
+		logger.Error(err, "Failed to read ConfigMap, not generating snippets")
+		return nil
+	}
+	existing, err := r.allSnippets(ctx)
+	if err != nil {
+		logger.Error(err, "Failed to list snippets, not generating snippets")
+		return nil
+	}
+	restore, err := r.pausedBy(ctx)
+	if err != nil {
+		logger.Error(err, "Failed to list restores, not generating snippets")
+		return nil
+	}
+	if restore != "" {
+		logger.Info("Reconciliation is paused, not generating snippets", "restore", restore)
+		return nil
+	}
+
+	for _, snippet := range r.snippetsForUnmanaged(awsauth.Mappings(), existing) {
+		if errs := snippet.Spec.Validate(field.NewPath("spec")); len(errs) > 0 {
+			logger.Info("Not generating snippet for invalid entry", "snippet", snippet.Name, "errors", errs.ToAggregate().Error())
+			continue
+		}
+		if r.Options.DryRun {
+			logger.Info("Would generate snippet for unmanaged entry", "snippet", snippetKey(snippet))
+			continue
+		}
+		err := r.Create(ctx, snippet)
+		if apierrs.IsAlreadyExists(err) {
+			continue
+		}
+		if err != nil {
+			logger.Error(err, "Failed to generate snippet", "snippet", snippet.Name)
+			continue
+		}
+		logger.Info("Generated snippet for unmanaged entry", "snippet", snippetKey(snippet))
+	}
+	return nil
+}
+
+/*
+snippetsForUnmanaged returns one adopting snippet for every entry that is
+neither owned, declared by one of the existing snippets nor protected.
+*/
+func (r *AwsAuthMapSnippetReconciler) snippetsForUnmanaged(current *Mappings, existing []Snippet) []*crdv1beta1.AwsAuthMapSnippet {
+	unmanaged := func(arn string) bool {
+		if _, owned := current.Owners[arn]; owned || r.Options.ProtectedArns.Matches(arn) {
+			return false
+		}
+		for _, snippet := range existing {
+			if snippetDeclares(snippet, arn) {
+				return false
+			}
+		}
+		return true
+	}
+
+	snippets := []*crdv1beta1.AwsAuthMapSnippet{}
+	for ra, mr := range current.Roles {
+		if unmanaged(ra) {
+			snippet := r.generatedSnippet("role", ra)
+			snippet.Spec.MapRoles = []crdv1beta1.MapRolesSpec{mr}
+			snippets = append(snippets, snippet)
+		}
+	}
+	for ua, mu := range current.Users {
+		if unmanaged(ua) {
+			snippet := r.generatedSnippet("user", ua)
+			snippet.Spec.MapUsers = []crdv1beta1.MapUsersSpec{mu}
+			snippets = append(snippets, snippet)
+		}
+	}
+	for aid := range current.Accounts {
+		if unmanaged(aid) {
+			snippet := r.generatedSnippet("account", aid)
+			snippet.Spec.MapAccounts = []crdv1beta1.AccountID{crdv1beta1.AccountID(aid)}
+			snippets = append(snippets, snippet)
+		}
+	}
+	return snippets
+}
+
+// generatedSnippet returns an empty adopting snippet for the ARN or account
+// ID.
+func (r *AwsAuthMapSnippetReconciler) generatedSnippet(kind, arn string) *crdv1beta1.AwsAuthMapSnippet {
+	return &crdv1beta1.AwsAuthMapSnippet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        generatedSnippetName(kind, arn),
+			Namespace:   r.Options.GenerateSnippetsNamespace,
+			Labels:      map[string]string{GENERATED_LABEL: "true"},
+			Annotations: map[string]string{ADOPT_ANNOTATION: "true"},
+		},
+		Spec: crdv1beta1.AwsAuthMapSnippetSpec{
+			ControllerClass: r.Options.ControllerClass,
+		},
+	}
+}
+
+/*
+generatedSnippetName derives a readable name from the kind and the resource
+part of the ARN, e.g. role-eks-node-1a2b3c4d for
+arn:aws:iam::111122223333:role/eks-node. The suffix is a hash of the whole
+ARN, which keeps the names of similar ARNs of different accounts apart.
+*/
+func generatedSnippetName(kind, arn string) string {
+	resource := arn[strings.LastIndex(arn, ":")+1:]
+	resource = strings.TrimPrefix(resource, kind+"/")
+	resource = invalidNameChars.ReplaceAllString(strings.ToLower(resource), "-")
+	if len(resource) > 40 {
+		resource = resource[:40]
+	}
+	resource = strings.Trim(resource, "-")
+
+	sum := sha256.Sum256([]byte(arn))
+	suffix := hex.EncodeToString(sum[:])[:8]
+	if resource == "" {
+		return kind + "-" + suffix
+	}
+	return kind + "-" + resource + "-" + suffix
+}
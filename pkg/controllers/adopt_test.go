@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"context"
+
+	crdv1beta1 "github.com/inovex/aws-auth-controller/pkg/api/v1beta1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("adoption of unmanaged entries", func() {
+	const (
+		NODE_ARN  = "arn:aws:iam::123456789012:role/eks-node"
+		ADMIN_ARN = "arn:aws:iam::123456789012:user/Admin.User"
+		OWNED_ARN = "arn:aws:iam::123456789012:role/owned"
+		ACCOUNT   = "123456789012"
+	)
+
+	var (
+		c       client.Client
+		backend *ConfigMapBackend
+	)
+
+	nodeRole := newRole(NODE_ARN, "system:node:{{EC2PrivateDNSName}}", "system:bootstrappers", "system:nodes")
+
+	BeforeEach(func() {
+		current := NewMappings()
+		current.Roles[NODE_ARN] = nodeRole
+		current.Roles[OWNED_ARN] = newRole(OWNED_ARN, "owned", "owned")
+		current.Users[ADMIN_ARN] = newUser(ADMIN_ARN, "admin", "system:masters")
+		current.Accounts[ACCOUNT] = true
+		current.Owners[OWNED_ARN] = "default/owned"
+		c = newFakeClient(newConfigMap(current))
+		backend = &ConfigMapBackend{Client: c}
+	})
+
+	adopting := func(mr crdv1beta1.MapRolesSpec) *crdv1beta1.AwsAuthMapSnippet {
+		snip := newSnippet("nodes", "")
+		snip.Annotations = map[string]string{ADOPT_ANNOTATION: "true"}
+		snip.Spec.MapRoles = []crdv1beta1.MapRolesSpec{mr}
+		return snip
+	}
+
+	It("should take ownership of equal entries without rewriting them", func() {
+		snip := adopting(nodeRole)
+		changes, err := backend.Apply(context.Background(), ComputeDesiredState([]Snippet{snip}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes.Empty()).To(BeTrue())
+		Expect(changes.Adopted).To(ConsistOf(NODE_ARN))
+
+		mappings, err := backend.Load(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mappings.Owners).To(HaveKeyWithValue(NODE_ARN, "default/nodes"))
+		Expect(mappings.Originals).To(BeEmpty())
+
+		// Adopted entries belong to the snippet and are removed with it.
+		changes, err = backend.Apply(context.Background(), ComputeDesiredState([]Snippet{deleted(snip)}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes.Removed).To(ConsistOf(NODE_ARN))
+	})
+
+	It("should remember differing entries as usual", func() {
+		changed := nodeRole
+		changed.Groups = []string{"system:nodes"}
+		changes, err := backend.Apply(context.Background(), ComputeDesiredState([]Snippet{adopting(changed)}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes.Updated).To(ConsistOf(NODE_ARN))
+		Expect(changes.Adopted).To(BeEmpty())
+
+		mappings, err := backend.Load(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mappings.Originals).To(HaveKey(NODE_ARN))
+	})
+
+	It("should derive readable and distinct names", func() {
+		Expect(generatedSnippetName("role", NODE_ARN)).To(MatchRegexp(`^role-eks-node-[0-9a-f]{8}$`))
+		Expect(generatedSnippetName("user", ADMIN_ARN)).To(MatchRegexp(`^user-admin-user-[0-9a-f]{8}$`))
+		Expect(generatedSnippetName("role", "arn:aws:iam::210987654321:role/eks-node")).
+			ToNot(Equal(generatedSnippetName("role", NODE_ARN)))
+		Expect(generatedSnippetName("account", ACCOUNT)).To(MatchRegexp(`^account-123456789012-[0-9a-f]{8}$`))
+	})
+
+	It("should generate adopting snippets for unmanaged entries", func() {
+		admins := newSnippet("admins", "")
+		admins.Spec.MapUsers = []crdv1beta1.MapUsersSpec{newUser(ADMIN_ARN, "admin", "system:masters")}
+		Expect(c.Create(context.Background(), admins)).To(Succeed())
+		r := &AwsAuthMapSnippetReconciler{
+			Client:  c,
+			Backend: backend,
+			Options: AwsAuthMapSnippetReconcilerOptions{GenerateSnippetsNamespace: "aws-auth"},
+		}
+		Expect(r.GenerateSnippets(context.Background())).To(Succeed())
+		// Running again must not fail on the existing snippets.
+		Expect(r.GenerateSnippets(context.Background())).To(Succeed())
+
+		generated := &crdv1beta1.AwsAuthMapSnippetList{}
+		Expect(c.List(context.Background(), generated, client.InNamespace("aws-auth"))).To(Succeed())
+		Expect(generated.Items).To(HaveLen(2))
+		declared := []string{}
+		for _, snip := range generated.Items {
+			Expect(snip.Annotations).To(HaveKeyWithValue(ADOPT_ANNOTATION, "true"))
+			Expect(snip.Labels).To(HaveKeyWithValue(GENERATED_LABEL, "true"))
+			for _, mr := range snip.Spec.MapRoles {
+				Expect(mr).To(Equal(nodeRole))
+				declared = append(declared, mr.RoleArn)
+			}
+			for _, ma := range snip.Spec.MapAccounts {
+				declared = append(declared, string(ma))
+			}
+		}
+		Expect(declared).To(ConsistOf(NODE_ARN, ACCOUNT))
+	})
+
+	It("should not generate snippets in dry-run mode or while paused", func() {
+		r := &AwsAuthMapSnippetReconciler{
+			Client:  c,
+			Backend: backend,
+			Options: AwsAuthMapSnippetReconcilerOptions{GenerateSnippetsNamespace: "aws-auth", DryRun: true},
+		}
+		Expect(r.GenerateSnippets(context.Background())).To(Succeed())
+
+		restore := &crdv1beta1.AwsAuthMapRestore{ObjectMeta: metav1.ObjectMeta{Name: "restore"}}
+		Expect(c.Create(context.Background(), restore)).To(Succeed())
+		r.Options.DryRun = false
+		Expect(r.GenerateSnippets(context.Background())).To(Succeed())
+
+		generated := &crdv1beta1.AwsAuthMapSnippetList{}
+		Expect(c.List(context.Background(), generated, client.InNamespace("aws-auth"))).To(Succeed())
+		Expect(generated.Items).To(BeEmpty())
+	})
+})
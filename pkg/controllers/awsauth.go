@@ -2,11 +2,16 @@ package controllers
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
 
 	crdv1beta1 "github.com/inovex/aws-auth-controller/pkg/api/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/yaml"
 )
 
@@ -14,19 +19,52 @@ const CONFIG_MAP_NAMESPACE = "kube-system"
 const CONFIG_MAP_NAME = "aws-auth"
 const MAP_ROLES_KEY = "mapRoles"
 const MAP_USERS_KEY = "mapUsers"
+const MAP_ACCOUNTS_KEY = "mapAccounts"
 const MANAGED_ANNOTATION = "awsauth.io/managed"
 
+// OWNERS_ANNOTATION holds the ownership index of the ConfigMap, a JSON object
+// that maps the ARNs and account IDs of all managed entries to the key of the
+// snippet that owns them.
+const OWNERS_ANNOTATION = "awsauth.io/owners"
+
+// ORIGINALS_ANNOTATION holds the unmanaged entries that were taken over by
+// snippets, a JSON object by ARN and account ID. They are put back once no
+// snippet declares them anymore.
+const ORIGINALS_ANNOTATION = "awsauth.io/originals"
+
+// DefaultConfigMapKey is the ConfigMap that is read by EKS.
+var DefaultConfigMapKey = client.ObjectKey{Namespace: CONFIG_MAP_NAMESPACE, Name: CONFIG_MAP_NAME}
+
 type MapRoles []crdv1beta1.MapRolesSpec
 type MapUsers []crdv1beta1.MapUsersSpec
+type MapAccounts []string
 
 type MapRolesByArn map[string]crdv1beta1.MapRolesSpec
 type MapUsersByArn map[string]crdv1beta1.MapUsersSpec
+type MapAccountsByID map[string]bool
 
 type AwsAuthMap struct {
 	client.Client
+	// Reader reads the ConfigMap again after a conflicting write. It should
+	// not be cached, as the cache may still hold the conflicting version.
+	// The Client is used if it is not set.
+	Reader client.Reader
+	// ConfigMapKey is the namespace and name of the ConfigMap. The aws-auth
+	// ConfigMap in kube-system is used if it is not set.
+	ConfigMapKey client.ObjectKey
+	// History stores a snapshot of the ConfigMap before every write, if set.
+	History   *History
 	ConfigMap *corev1.ConfigMap
 	Roles     *MapRolesByArn
 	Users     *MapUsersByArn
+	Accounts  *MapAccountsByID
+	// Owners is the ownership index stored in OWNERS_ANNOTATION.
+	Owners map[string]string
+	// Originals are the entries stored in ORIGINALS_ANNOTATION.
+	Originals map[string]OriginalEntry
+	// Protection refuses writes that lock out administrators or nodes, if
+	// set.
+	Protection *LockoutProtection
 }
 
 /*
@@ -48,16 +86,14 @@ getOrCreate reads the ConfigMap from the API or creates it if it did not exist.
 */
 func (a *AwsAuthMap) getOrCreate(ctx context.Context) error {
 	authCM := &corev1.ConfigMap{}
-	err := a.Get(ctx, client.ObjectKey{
-		Namespace: CONFIG_MAP_NAMESPACE,
-		Name:      CONFIG_MAP_NAME,
-	}, authCM)
+	key := a.key()
+	err := a.Get(ctx, key, authCM)
 
 	if err != nil {
 		// Check for missing ConfigMap and create
 		if apierrs.IsNotFound(err) {
-			authCM.ObjectMeta.Namespace = CONFIG_MAP_NAMESPACE
-			authCM.ObjectMeta.Name = CONFIG_MAP_NAME
+			authCM.ObjectMeta.Namespace = key.Namespace
+			authCM.ObjectMeta.Name = key.Name
 			authCM.Data = make(map[string]string)
 			authCM.Data[MAP_ROLES_KEY] = ""
 			authCM.Data[MAP_USERS_KEY] = ""
@@ -73,21 +109,54 @@ func (a *AwsAuthMap) getOrCreate(ctx context.Context) error {
 	return nil
 }
 
+// key returns the namespace and name of the ConfigMap.
+func (a *AwsAuthMap) key() client.ObjectKey {
+	if a.ConfigMapKey.Name == "" {
+		return DefaultConfigMapKey
+	}
+	return a.ConfigMapKey
+}
+
 /*
 Read retrieves the ConfigMap, deserializes the contained YaML objects and maps
 their content by ARN for easy manipulation.
 */
 func (a *AwsAuthMap) Read(ctx context.Context) error {
-	rolesByArn := MapRolesByArn{}
-	usersByArn := MapUsersByArn{}
-
 	err := a.getOrCreate(ctx)
 	if err != nil {
 		return err
 	}
+	return a.parse()
+}
+
+/*
+reread retrieves the ConfigMap again through the Reader, after a write failed
+because it was modified concurrently.
+*/
+func (a *AwsAuthMap) reread(ctx context.Context) error {
+	if a.Reader == nil {
+		return a.Read(ctx)
+	}
+	cm := &corev1.ConfigMap{}
+	if err := a.Reader.Get(ctx, a.key(), cm); err != nil {
+		return err
+	}
+	a.ConfigMap = cm
+	return a.parse()
+}
+
+/*
+parse deserializes the YaML objects contained in the ConfigMap and maps their
+content by ARN.
+*/
+func (a *AwsAuthMap) parse() error {
+	rolesByArn := MapRolesByArn{}
+	usersByArn := MapUsersByArn{}
+	accountsByID := MapAccountsByID{}
+
 	// Create map of MapRolesSpec
 	currentMapRoles := &MapRoles{}
-	err = yaml.Unmarshal([]byte(a.ConfigMap.Data[MAP_ROLES_KEY]), currentMapRoles)
+	err := yaml.Unmarshal([]byte(a.ConfigMap.Data[MAP_ROLES_KEY]), currentMapRoles)
 	if err != nil {
 		return err
 	}
@@ -103,57 +172,277 @@ func (a *AwsAuthMap) Read(ctx context.Context) error {
 	for _, cmu := range *currentMapUsers {
 		usersByArn[cmu.UserArn] = cmu
 	}
+	// Create set of account IDs
+	currentMapAccounts, err := parseMapAccounts(a.ConfigMap.Data[MAP_ACCOUNTS_KEY])
+	if err != nil {
+		return err
+	}
+	for _, cma := range currentMapAccounts {
+		accountsByID[cma] = true
+	}
+	// Read the ownership index
+	owners := map[string]string{}
+	if index, ok := a.ConfigMap.Annotations[OWNERS_ANNOTATION]; ok {
+		if err := json.Unmarshal([]byte(index), &owners); err != nil {
+			return fmt.Errorf("parsing annotation %s: %w", OWNERS_ANNOTATION, err)
+		}
+	}
+	a.Owners = owners
+	// Read the original entries
+	originals := map[string]OriginalEntry{}
+	if data, ok := a.ConfigMap.Annotations[ORIGINALS_ANNOTATION]; ok {
+		if err := json.Unmarshal([]byte(data), &originals); err != nil {
+			return fmt.Errorf("parsing annotation %s: %w", ORIGINALS_ANNOTATION, err)
+		}
+	}
+	a.Originals = originals
 	a.Roles = &rolesByArn
 	a.Users = &usersByArn
+	a.Accounts = &accountsByID
 	return nil
 }
 
 /*
-Write serializes the mappings back to YaM and writes them to the ConfigMap
-API object.
+parseMapAccounts deserializes the list of account IDs.
 
-It is important to re-use the ConfigMap object that was retrieved by Read so
-that the contained ResourceVersion attribute can be evaluated by the API server
-to catch concurrent writes.
+Account IDs are usually quoted, but plain numbers are accepted as well since
+they are valid YaML.
 */
-func (a *AwsAuthMap) Write(ctx context.Context) error {
+func parseMapAccounts(data string) (MapAccounts, error) {
+	raw := []interface{}{}
+	if err := yaml.Unmarshal([]byte(data), &raw); err != nil {
+		return nil, err
+	}
+	mapAccounts := MapAccounts{}
+	for _, r := range raw {
+		switch id := r.(type) {
+		case string:
+			mapAccounts = append(mapAccounts, id)
+		case float64:
+			mapAccounts = append(mapAccounts, fmt.Sprintf("%012.0f", id))
+		default:
+			return nil, fmt.Errorf("invalid account ID in %s: %v", MAP_ACCOUNTS_KEY, r)
+		}
+	}
+	return mapAccounts, nil
+}
 
+/*
+marshalMapRoles serializes the role mappings as stored in the ConfigMap. The
+entries are sorted by ARN so that the output is stable, the order of the
+groups within an entry is kept as it is.
+*/
+func marshalMapRoles(roles MapRolesByArn) ([]byte, error) {
 	mapRoles := MapRoles{}
-	mapUsers := MapUsers{}
-
-	// Create arrays of map content
-	for _, rba := range *a.Roles {
+	for _, rba := range roles {
 		mapRoles = append(mapRoles, rba)
 	}
-	for _, uba := range *a.Users {
+	sort.Slice(mapRoles, func(i, j int) bool {
+		return mapRoles[i].RoleArn < mapRoles[j].RoleArn
+	})
+	return yaml.Marshal(mapRoles)
+}
+
+// marshalMapUsers serializes the user mappings like marshalMapRoles.
+func marshalMapUsers(users MapUsersByArn) ([]byte, error) {
+	mapUsers := MapUsers{}
+	for _, uba := range users {
 		mapUsers = append(mapUsers, uba)
 	}
+	sort.Slice(mapUsers, func(i, j int) bool {
+		return mapUsers[i].UserArn < mapUsers[j].UserArn
+	})
+	return yaml.Marshal(mapUsers)
+}
+
+/*
+Write serializes the mappings back to YaML and writes them to the ConfigMap
+API object. The entries are sorted by ARN and the API call is skipped if the
+serialized data did not change. Otherwise the previous content is stored in
+the History first, if set.
+
+It is important to re-use the ConfigMap object that was retrieved by Read so
+that the contained ResourceVersion attribute can be evaluated by the API server
+to catch concurrent writes.
+*/
+func (a *AwsAuthMap) Write(ctx context.Context) error {
 
-	// Make Yaml strings from arrays
-	mapRolesYaml, err := yaml.Marshal(mapRoles)
+	mapRolesYaml, err := marshalMapRoles(*a.Roles)
+	if err != nil {
+		return err
+	}
+	mapUsersYaml, err := marshalMapUsers(*a.Users)
+	if err != nil {
+		return err
+	}
+	mapAccounts := MapAccounts{}
+	for aid := range *a.Accounts {
+		mapAccounts = append(mapAccounts, aid)
+	}
+	sort.Strings(mapAccounts)
+	mapAccountsYaml, err := yaml.Marshal(mapAccounts)
 	if err != nil {
 		return err
 	}
-	mapUsersYaml, err := yaml.Marshal(mapUsers)
+
+	data := map[string]string{
+		MAP_ROLES_KEY: string(mapRolesYaml),
+		MAP_USERS_KEY: string(mapUsersYaml),
+	}
+	// Only add the accounts if needed, most clusters don't use them.
+	if _, ok := a.ConfigMap.Data[MAP_ACCOUNTS_KEY]; ok || len(mapAccounts) > 0 {
+		data[MAP_ACCOUNTS_KEY] = string(mapAccountsYaml)
+	}
+	ownersJson, originalsJson, err := a.marshalOwners()
 	if err != nil {
 		return err
 	}
 
+	// Skip the write if nothing changed.
+	changed := a.ConfigMap.ObjectMeta.Annotations[MANAGED_ANNOTATION] != "true" ||
+		a.ConfigMap.ObjectMeta.Annotations[OWNERS_ANNOTATION] != ownersJson ||
+		a.ConfigMap.ObjectMeta.Annotations[ORIGINALS_ANNOTATION] != originalsJson
+	for key, value := range data {
+		if current, ok := a.ConfigMap.Data[key]; !ok || current != value {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	if a.Protection != nil {
+		// The ConfigMap still holds the previous content.
+		before := &AwsAuthMap{ConfigMap: a.ConfigMap}
+		if err := before.parse(); err != nil {
+			return err
+		}
+		if err := a.Protection.Check(before.Mappings(), a.Mappings()); err != nil {
+			configMapWritesBlocked.Inc()
+			return err
+		}
+	}
+
+	if a.History != nil {
+		if err := a.History.Snapshot(ctx, a.ConfigMap); err != nil {
+			return fmt.Errorf("snapshotting ConfigMap: %w", err)
+		}
+	}
+
 	if a.ConfigMap.ObjectMeta.Annotations == nil {
 		// No annotations yet.
 		a.ConfigMap.ObjectMeta.Annotations = make(map[string]string)
 	}
 	a.ConfigMap.ObjectMeta.Annotations[MANAGED_ANNOTATION] = "true"
+	a.ConfigMap.ObjectMeta.Annotations[OWNERS_ANNOTATION] = ownersJson
+	if originalsJson != "" {
+		a.ConfigMap.ObjectMeta.Annotations[ORIGINALS_ANNOTATION] = originalsJson
+	} else {
+		delete(a.ConfigMap.ObjectMeta.Annotations, ORIGINALS_ANNOTATION)
+	}
 
 	// Store Yaml mappings in ConfigMap.
-	a.ConfigMap.Data[MAP_ROLES_KEY] = string(mapRolesYaml)
-	a.ConfigMap.Data[MAP_USERS_KEY] = string(mapUsersYaml)
+	if a.ConfigMap.Data == nil {
+		a.ConfigMap.Data = make(map[string]string)
+	}
+	for key, value := range data {
+		a.ConfigMap.Data[key] = value
+	}
+
+	configMapWriteAttempts.Inc()
+	return a.Update(ctx, a.ConfigMap)
+}
+
+/*
+marshalOwners serializes the ownership index and the original entries, see
+Mappings.index. The originals are empty if there are none, so that the
+annotation is only added if needed.
+*/
+func (a *AwsAuthMap) marshalOwners() (string, string, error) {
+	owners, originals := a.Mappings().index()
 
-	err = a.Update(ctx, a.ConfigMap)
+	// Map keys are sorted, so the output is stable.
+	ownersJson, err := json.Marshal(owners)
 	if err != nil {
-		// TODO: Deal with 409 responses (concurrent write).
-		return err
+		return "", "", err
+	}
+	if len(originals) == 0 {
+		return string(ownersJson), "", nil
+	}
+	originalsJson, err := json.Marshal(originals)
+	if err != nil {
+		return "", "", err
 	}
+	return string(ownersJson), string(originalsJson), nil
+}
 
-	return nil
+/*
+Apply merges the desired state into the ConfigMap and writes it. It returns
+the entries that were changed by the write.
+
+Entries whose change is refused by the Protection are kept as they are and
+reported in Changes.Blocked, all other entries are written nevertheless.
+
+If the write fails because the ConfigMap was modified concurrently, e.g. by
+EKS or eksctl, the ConfigMap is read again through the Reader and the desired
+state is merged into the fresh copy before the next attempt. The number of attempts is bounded
+by retry.DefaultRetry.
+*/
+func (a *AwsAuthMap) Apply(ctx context.Context, desired *DesiredState) (*Changes, error) {
+	logger := log.FromContext(ctx)
+	attempt := 0
+	changes := &Changes{}
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		attempt++
+		if attempt > 1 {
+			logger.Info("Concurrent modification of ConfigMap, retrying", "attempt", attempt)
+			configMapWriteConflicts.Inc()
+			if err := a.reread(ctx); err != nil {
+				return err
+			}
+		}
+		before := a.Mappings().Copy()
+		a.Merge(desired)
+		blocked := a.Protection.Enforce(before, a.Mappings())
+		changes = diffMappings(before, a.Mappings())
+		changes.Blocked = blocked
+		return a.Write(ctx)
+	})
+	if err != nil {
+		configMapWriteFailures.Inc()
+		return nil, err
+	}
+	if len(changes.Blocked) > 0 {
+		configMapWritesBlocked.Inc()
+	}
+	return changes, nil
+}
+
+/*
+Mappings returns the mappings of the ConfigMap. The maps are shared with the
+AwsAuthMap, so modifications are written by the next Write.
+*/
+func (a *AwsAuthMap) Mappings() *Mappings {
+	if a.Owners == nil {
+		a.Owners = map[string]string{}
+	}
+	if a.Originals == nil {
+		a.Originals = map[string]OriginalEntry{}
+	}
+	return &Mappings{
+		Roles:     *a.Roles,
+		Users:     *a.Users,
+		Accounts:  *a.Accounts,
+		Owners:    a.Owners,
+		Originals: a.Originals,
+	}
+}
+
+/*
+Merge replaces all managed entries with the ones from the desired state.
+
+Entries that were never managed by a snippet are kept as they are.
+*/
+func (a *AwsAuthMap) Merge(desired *DesiredState) {
+	a.Mappings().Merge(desired)
 }
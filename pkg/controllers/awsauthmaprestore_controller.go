@@ -0,0 +1,229 @@
+/*
+Copyright 2023 inovex GmbH
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	crdv1beta1 "github.com/inovex/aws-auth-controller/pkg/api/v1beta1"
+)
+
+// Reasons of the events that are recorded on restores.
+const (
+	EventReasonRestored      = "Restored"
+	EventReasonRestoreFailed = "RestoreFailed"
+	EventReasonResumed       = "Resumed"
+)
+
+/*
+AwsAuthMapRestoreReconciler reconciles an AwsAuthMapRestore object.
+
+It writes the mapRoles and mapUsers of the chosen snapshot to the ConfigMap.
+While the restore exists and is not resumed the snippet reconcilers do not
+write any mappings. Once it is resumed or deleted, all snippets are applied
+again.
+*/
+type AwsAuthMapRestoreReconciler struct {
+	*AwsAuthMapSnippetReconciler
+}
+
+//+kubebuilder:rbac:groups=crd.awsauth.io,resources=awsauthmaprestores,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=crd.awsauth.io,resources=awsauthmaprestores/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;delete
+
+// Reconcile is part of the main kubernetes reconciliation loop which aims to
+// move the current state of the cluster closer to the desired state.
+func (r *AwsAuthMapRestoreReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	logger.Info("Reconcile Request received", "objectName", req.NamespacedName)
+
+	restore := &crdv1beta1.AwsAuthMapRestore{}
+	err := r.Get(ctx, client.ObjectKey{Name: req.NamespacedName.Name}, restore)
+	if err != nil {
+		if apierrs.IsNotFound(err) {
+			logger.Info("Restore deleted, resuming reconciliation")
+			return ctrl.Result{}, r.resume(ctx)
+		}
+		return ctrl.Result{}, err
+	}
+
+	original := restore.DeepCopy()
+	defer func() {
+		if err := r.Status().Patch(ctx, restore, client.MergeFrom(original)); err != nil {
+			logger.Error(err, "Failed to update status")
+		}
+	}()
+
+	if restore.Spec.Resume {
+		meta.SetStatusCondition(&restore.Status.Conditions, metav1.Condition{
+			Type:               crdv1beta1.ConditionPaused,
+			Status:             metav1.ConditionFalse,
+			Reason:             crdv1beta1.ReasonResumed,
+			Message:            "Snippets are reconciled again",
+			ObservedGeneration: restore.Generation,
+		})
+		if err := r.resume(ctx); err != nil {
+			return ctrl.Result{}, err
+		}
+		r.Recorder.Event(restore, corev1.EventTypeNormal, EventReasonResumed, "Resumed the reconciliation of snippets")
+		return ctrl.Result{}, nil
+	}
+
+	meta.SetStatusCondition(&restore.Status.Conditions, metav1.Condition{
+		Type:               crdv1beta1.ConditionPaused,
+		Status:             metav1.ConditionTrue,
+		Reason:             crdv1beta1.ReasonPausedByRestore,
+		Message:            "Snippets are not reconciled until spec.resume is set or the restore is deleted",
+		ObservedGeneration: restore.Generation,
+	})
+	if restore.Status.RestoredSnapshot == restore.Spec.Snapshot {
+		return ctrl.Result{}, nil
+	}
+
+	if err := r.restoreSnapshot(ctx, restore); err != nil {
+		logger.Error(err, "Failed to restore snapshot", "snapshot", restore.Spec.Snapshot)
+		meta.SetStatusCondition(&restore.Status.Conditions, metav1.Condition{
+			Type:               crdv1beta1.ConditionRestored,
+			Status:             metav1.ConditionFalse,
+			Reason:             crdv1beta1.ReasonRestoreFailed,
+			Message:            err.Error(),
+			ObservedGeneration: restore.Generation,
+		})
+		r.Recorder.Event(restore, corev1.EventTypeWarning, EventReasonRestoreFailed,
+			fmt.Sprintf("Failed to restore snapshot %s: %s", restore.Spec.Snapshot, err))
+		return ctrl.Result{}, err
+	}
+
+	restore.Status.RestoredSnapshot = restore.Spec.Snapshot
+	now := metav1.Now()
+	restore.Status.RestoreTime = &now
+	meta.SetStatusCondition(&restore.Status.Conditions, metav1.Condition{
+		Type:               crdv1beta1.ConditionRestored,
+		Status:             metav1.ConditionTrue,
+		Reason:             crdv1beta1.ReasonSnapshotRestored,
+		Message:            fmt.Sprintf("Restored snapshot %s", restore.Spec.Snapshot),
+		ObservedGeneration: restore.Generation,
+	})
+	r.Recorder.Event(restore, corev1.EventTypeNormal, EventReasonRestored,
+		fmt.Sprintf("Restored snapshot %s", restore.Spec.Snapshot))
+	logger.Info("Restored snapshot", "snapshot", restore.Spec.Snapshot)
+	return ctrl.Result{}, nil
+}
+
+/*
+restoreSnapshot replaces mapRoles and mapUsers of the ConfigMap with the ones
+of the snapshot. The current content is stored in the history first, so the
+restore can be undone by another one. Protected entries are kept as they are.
+
+The ownership index and the original entries are restored as well, so that
+the snippets take over their entries again once resumed. Snapshots taken
+before they were recorded keep the current ones. As mapAccounts is not
+restored, the current owners and originals of accounts are kept in any case.
+*/
+func (r *AwsAuthMapRestoreReconciler) restoreSnapshot(ctx context.Context, restore *crdv1beta1.AwsAuthMapRestore) error {
+	cmb := r.configMapBackend()
+	if cmb == nil || cmb.History == nil {
+		return errors.New("restoring needs the configmap backend with a history")
+	}
+	snapshot, err := cmb.History.Get(ctx, restore.Spec.Snapshot)
+	if err != nil {
+		return err
+	}
+	restored := &AwsAuthMap{ConfigMap: snapshot}
+	if err := restored.parse(); err != nil {
+		return fmt.Errorf("parsing snapshot %s: %w", snapshot.Name, err)
+	}
+
+	ctx = WithTrigger(ctx, "AwsAuthMapRestore/"+restore.Name)
+	awsauth := cmb.awsAuthMap()
+	// After a conflict the cache may still hold the conflicting version.
+	read := awsauth.Read
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := read(ctx); err != nil {
+			return err
+		}
+		read = awsauth.reread
+		if _, ok := snapshot.Annotations[OWNERS_ANNOTATION]; !ok {
+			restored.Owners = awsauth.Owners
+			restored.Originals = awsauth.Originals
+		} else {
+			keepAccounts(awsauth.Mappings(), restored.Mappings())
+		}
+		r.Options.ProtectedArns.keepProtected(awsauth.Mappings(), restored.Mappings())
+		awsauth.Roles = restored.Roles
+		awsauth.Users = restored.Users
+		awsauth.Owners = restored.Owners
+		awsauth.Originals = restored.Originals
+		return awsauth.Write(ctx)
+	})
+	if err == nil && r.primaryBackend() == Backend(cmb) {
+		r.rememberMappings(awsauth.Mappings())
+	}
+	return err
+}
+
+/*
+keepAccounts replaces the owners and originals of accounts in the restored
+mappings with the current ones, as the accounts themselves are not restored.
+*/
+func keepAccounts(current, restored *Mappings) {
+	for _, index := range []map[string]bool{restored.Accounts, current.Accounts} {
+		for aid := range index {
+			delete(restored.Owners, aid)
+			delete(restored.Originals, aid)
+		}
+	}
+	for aid := range current.Accounts {
+		if owner, ok := current.Owners[aid]; ok {
+			restored.Owners[aid] = owner
+		}
+		if original, ok := current.Originals[aid]; ok {
+			restored.Originals[aid] = original
+		}
+	}
+}
+
+// resume applies all snippets unless another restore still pauses the
+// reconciliation.
+func (r *AwsAuthMapRestoreReconciler) resume(ctx context.Context) error {
+	restore, err := r.pausedBy(ctx)
+	if err != nil || restore != "" {
+		return err
+	}
+	return r.syncConfigMap(ctx, nil)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *AwsAuthMapRestoreReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		// Ignore updates of the status, the controller writes it itself.
+		For(&crdv1beta1.AwsAuthMapRestore{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Complete(r)
+}
@@ -0,0 +1,126 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	crdv1beta1 "github.com/inovex/aws-auth-controller/pkg/api/v1beta1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+var _ = Describe("restore controller", func() {
+	BeforeEach(requireEnvtest)
+
+	const USER_ARN = "arn:aws:iam::123456789012:user/restored"
+
+	// restoreSnippet creates a snippet that maps USER_ARN to the given
+	// username and waits until it is written.
+	restoreSnippet := func(name string) *crdv1beta1.AwsAuthMapSnippet {
+		snip := newSnippet(name, "snippet-name", USER_ARN)
+		Expect(k8sClient.Create(context.Background(), snip)).To(Succeed())
+		DeferCleanup(func() {
+			Expect(client.IgnoreNotFound(k8sClient.Delete(context.Background(), snip))).To(Succeed())
+		})
+		Eventually(configMapUserName(USER_ARN), time.Second*10, time.Second).Should(Equal("snippet-name"))
+		return snip
+	}
+
+	// snapshot stores a snapshot that maps USER_ARN to the given username and
+	// returns its name.
+	snapshot := func(userName string) string {
+		mapUsers, err := yaml.Marshal([]crdv1beta1.MapUsersSpec{newUser(USER_ARN, userName, "foobar-group")})
+		Expect(err).ToNot(HaveOccurred())
+		cm := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "aws-auth", Namespace: "kube-system"},
+			Data:       map[string]string{MAP_USERS_KEY: string(mapUsers)},
+		}
+		Expect(history.Snapshot(context.Background(), cm)).To(Succeed())
+		snapshots, err := history.List(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(snapshots).ToNot(BeEmpty())
+		return snapshots[0].Name
+	}
+
+	// restoreOf creates a restore of the snapshot, which is deleted at the end
+	// of the spec so that other specs are not paused.
+	restoreOf := func(name, snapshot string) *crdv1beta1.AwsAuthMapRestore {
+		restore := &crdv1beta1.AwsAuthMapRestore{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       crdv1beta1.AwsAuthMapRestoreSpec{Snapshot: snapshot},
+		}
+		Expect(k8sClient.Create(context.Background(), restore)).To(Succeed())
+		DeferCleanup(func() {
+			Expect(client.IgnoreNotFound(k8sClient.Delete(context.Background(), restore))).To(Succeed())
+		})
+		return restore
+	}
+
+	// syncedReason returns the reason of the Synced condition of the snippet.
+	syncedReason := func(snip *crdv1beta1.AwsAuthMapSnippet) func() string {
+		return func() string {
+			if err := k8sClient.Get(context.Background(), client.ObjectKeyFromObject(snip), snip); err != nil {
+				return ""
+			}
+			synced := meta.FindStatusCondition(snip.Status.Conditions, crdv1beta1.ConditionSynced)
+			if synced == nil || synced.Status != metav1.ConditionFalse {
+				return ""
+			}
+			return synced.Reason
+		}
+	}
+
+	// pauseSnippet restores a snapshot that differs from the snippet and
+	// checks that the snippet is paused instead of overwriting it.
+	pauseSnippet := func(snip *crdv1beta1.AwsAuthMapSnippet, restoreName string) *crdv1beta1.AwsAuthMapRestore {
+		restore := restoreOf(restoreName, snapshot("restored-name"))
+		Eventually(func() bool {
+			if err := k8sClient.Get(context.Background(), client.ObjectKeyFromObject(restore), restore); err != nil {
+				return false
+			}
+			return meta.IsStatusConditionTrue(restore.Status.Conditions, crdv1beta1.ConditionRestored) &&
+				meta.IsStatusConditionTrue(restore.Status.Conditions, crdv1beta1.ConditionPaused)
+		}, time.Second*10, time.Second).Should(BeTrue())
+		Expect(configMapUserName(USER_ARN)()).To(Equal("restored-name"))
+
+		// A change of the snippet is not applied while the restore pauses
+		// the reconciliation.
+		Expect(k8sClient.Get(context.Background(), client.ObjectKeyFromObject(snip), snip)).To(Succeed())
+		snip.Spec.MapUsers[0].Groups = []string{"changed-group"}
+		Expect(k8sClient.Update(context.Background(), snip)).To(Succeed())
+		Eventually(syncedReason(snip), time.Second*10, time.Second).Should(Equal(crdv1beta1.ReasonPausedByRestore))
+		Expect(meta.IsStatusConditionTrue(snip.Status.Conditions, crdv1beta1.ConditionReady)).To(BeFalse())
+		Consistently(configMapUserName(USER_ARN), time.Second*3, time.Second).Should(Equal("restored-name"))
+		return restore
+	}
+
+	It("should restore a snapshot and resume once spec.resume is set", func() {
+		snip := restoreSnippet("restoresnip1")
+		restore := pauseSnippet(snip, "restore1")
+
+		Expect(k8sClient.Get(context.Background(), client.ObjectKeyFromObject(restore), restore)).To(Succeed())
+		restore.Spec.Resume = true
+		Expect(k8sClient.Update(context.Background(), restore)).To(Succeed())
+		Eventually(configMapUserName(USER_ARN), time.Second*10, time.Second).Should(Equal("snippet-name"))
+		Eventually(func() bool {
+			if err := k8sClient.Get(context.Background(), client.ObjectKeyFromObject(restore), restore); err != nil {
+				return false
+			}
+			paused := meta.FindStatusCondition(restore.Status.Conditions, crdv1beta1.ConditionPaused)
+			return paused != nil && paused.Status == metav1.ConditionFalse && paused.Reason == crdv1beta1.ReasonResumed
+		}, time.Second*10, time.Second).Should(BeTrue())
+	})
+
+	It("should resume once the restore is deleted", func() {
+		snip := restoreSnippet("restoresnip2")
+		restore := pauseSnippet(snip, "restore2")
+
+		Expect(k8sClient.Delete(context.Background(), restore)).To(Succeed())
+		Eventually(configMapUserName(USER_ARN), time.Second*10, time.Second).Should(Equal("snippet-name"))
+	})
+})
@@ -18,13 +18,30 @@ package controllers
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
 
+	corev1 "k8s.io/api/core/v1"
 	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	crdv1beta1 "github.com/inovex/aws-auth-controller/pkg/api/v1beta1"
 	"github.com/inovex/aws-auth-controller/pkg/predicates"
@@ -34,21 +51,71 @@ import (
 // AwsAuthMapSnippetReconciler
 type AwsAuthMapSnippetReconcilerOptions struct {
 	Namespaces []string
+
+	// ResyncPeriod is the interval of full recomputes of the ConfigMap.
+	// Zero disables the periodic resync.
+	ResyncPeriod time.Duration
+
+	// ControllerClass is the class of snippets handled by the controller.
+	// Snippets of other classes are ignored.
+	ControllerClass string
+
+	// DryRun only reports the changes of all snippets instead of writing
+	// them, as if every snippet had the dry-run annotation.
+	DryRun bool
+
+	// OrphanPolicy decides whether entries of the ConfigMap whose snippet
+	// does not exist anymore are removed (delete) or only reported (report).
+	OrphanPolicy string
+	// OrphanSweepPeriod is the interval of the search for orphaned entries.
+	// Zero only searches once at startup.
+	OrphanSweepPeriod time.Duration
+
+	// ProtectedArns are never modified or removed. Snippets that declare
+	// them get a Rejected condition.
+	ProtectedArns ProtectedArns
+
+	// GenerateSnippetsNamespace enables the generation of adopting snippets
+	// for all unmanaged entries at startup, in the given namespace.
+	GenerateSnippetsNamespace string
 }
 
 // AwsAuthMapSnippetReconciler reconciles an AwsAuthMapSnippet object
 type AwsAuthMapSnippetReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// Backend stores the mappings, the aws-auth ConfigMap if not set.
+	Backend Backend
 
 	Options AwsAuthMapSnippetReconcilerOptions
+
+	// applied are the mappings of the primary backend after the last write,
+	// which the metrics are computed from.
+	appliedMutex sync.Mutex
+	applied      *Mappings
+
+	// drifted are the ARNs and account IDs whose entries were found modified
+	// in the ConfigMap and not yet restored.
+	driftedMutex sync.Mutex
+	drifted      map[string]bool
 }
 
 const FINALIZER_NAME = "awsauth.io/finalizer"
 
+// pausedRequeueInterval is the interval in which snippets are reconciled again
+// while the reconciliation is paused by an AwsAuthMapRestore.
+const pausedRequeueInterval = 30 * time.Second
+
+// DRY_RUN_ANNOTATION puts a single snippet in dry-run mode if set to "true".
+const DRY_RUN_ANNOTATION = "awsauth.io/dry-run"
+
 //+kubebuilder:rbac:groups=crd.awsauth.io,resources=awsauthmapsnippets,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=crd.awsauth.io,resources=awsauthmapsnippets/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=crd.awsauth.io,resources=awsauthmapsnippets/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -58,14 +125,8 @@ func (r *AwsAuthMapSnippetReconciler) Reconcile(ctx context.Context, req ctrl.Re
 	logger := log.FromContext(ctx)
 	logger.Info("Reconcile Request received", "objectName", req.NamespacedName)
 
-	awsauthmap, err := GetAwsAuthMap(r.Client, ctx)
-	if err != nil {
-		return ctrl.Result{}, err
-	}
-	logger.Info("Read config map", "Roles", awsauthmap.Roles, "Users", awsauthmap.Users)
-
 	snippet := &crdv1beta1.AwsAuthMapSnippet{}
-	err = r.Get(ctx, client.ObjectKey{
+	err := r.Get(ctx, client.ObjectKey{
 		Name:      req.NamespacedName.Name,
 		Namespace: req.NamespacedName.Namespace},
 		snippet)
@@ -76,9 +137,58 @@ func (r *AwsAuthMapSnippetReconciler) Reconcile(ctx context.Context, req ctrl.Re
 		}
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
+	return r.reconcileSnippet(ctx, snippet)
+}
+
+/*
+reconcileSnippet handles the finalizer of a snippet of either kind and updates
+the backend and the snippet status.
+*/
+func (r *AwsAuthMapSnippetReconciler) reconcileSnippet(ctx context.Context, snippet Snippet) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	dryRun := r.isDryRun(snippet)
 
-	// examine DeletionTimestamp to determine if object is under deletion
-	if snippet.ObjectMeta.DeletionTimestamp.IsZero() {
+	restore, err := r.pausedBy(ctx)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if restore != "" {
+		logger.Info("Reconciliation is paused", "restore", restore)
+		if snippet.GetDeletionTimestamp().IsZero() {
+			original := snippet.DeepCopyObject().(Snippet)
+			setPausedCondition(snippet, restore)
+			setReadyCondition(snippet)
+			if err := r.Status().Patch(ctx, snippet, client.MergeFrom(original)); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{RequeueAfter: pausedRequeueInterval}, nil
+	}
+
+	if dryRun {
+		// Neither add nor remove the finalizer in dry-run mode, only report
+		// what the deletion would remove. A snippet that was applied before
+		// keeps its finalizer, so that it is not deleted without removing its
+		// mappings.
+		if !snippet.GetDeletionTimestamp().IsZero() {
+			if !containsString(snippet.GetFinalizers(), FINALIZER_NAME) {
+				return ctrl.Result{}, nil
+			}
+			original := snippet.DeepCopyObject().(Snippet)
+			err := r.CleanUpConfigMap(ctx, snippet)
+			if err == nil {
+				setDryRunCondition(snippet)
+			} else {
+				setSyncedCondition(snippet, err)
+			}
+			setReadyCondition(snippet)
+			if err := r.Status().Patch(ctx, snippet, client.MergeFrom(original)); err != nil {
+				logger.Error(err, "Failed to update status")
+			}
+			return ctrl.Result{}, err
+		}
+	} else if snippet.GetDeletionTimestamp().IsZero() {
+		// examine DeletionTimestamp to determine if object is under deletion.
 		// The object is not being deleted, so if it does not have our finalizer,
 		// then lets add the finalizer and update the object. This is equivalent
 		// registering our finalizer.
@@ -93,9 +203,19 @@ func (r *AwsAuthMapSnippetReconciler) Reconcile(ctx context.Context, req ctrl.Re
 		if containsString(snippet.GetFinalizers(), FINALIZER_NAME) {
 			// our finalizer is present, so lets handle any external dependency
 			logger.Info("Finalizer called")
-			if err := r.CleanUpConfigMap(ctx, snippet, awsauthmap); err != nil {
+			original := snippet.DeepCopyObject().(Snippet)
+			if err := r.CleanUpConfigMap(ctx, snippet); err != nil {
+				// Keep the snippet and report why, e.g. if the removal of its
+				// mappings is blocked by the lock-out protection.
+				setSyncedCondition(snippet, err)
+				setReadyCondition(snippet)
+				if err := r.Status().Patch(ctx, snippet, client.MergeFrom(original)); err != nil {
+					logger.Error(err, "Failed to update status")
+				}
 				return ctrl.Result{}, err
 			}
+			r.Recorder.Event(snippet, corev1.EventTypeNormal, EventReasonFinalized,
+				"Removed all mappings of the snippet")
 
 			// remove our finalizer from the list and update it.
 			controllerutil.RemoveFinalizer(snippet, FINALIZER_NAME)
@@ -108,127 +228,539 @@ func (r *AwsAuthMapSnippetReconciler) Reconcile(ctx context.Context, req ctrl.Re
 	}
 
 	// Prepare patch, remember original resource content
-	original := snippet.DeepCopy()
+	original := snippet.DeepCopyObject().(Snippet)
 	defer func() {
 		if err := r.UpdateSnippetStatus(ctx, snippet, original); err != nil {
 			logger.Error(err, "Failed to update status")
 		}
 	}()
 
-	snippet.Status.IsSynced = false
-
-	logger.Info("Updating ConfigMap")
-	if err := r.UpdateConfigMap(ctx, snippet, awsauthmap); err != nil {
-		logger.Error(err, "Failed to update ConfigMap")
+	logger.Info("Updating mappings", "backend", r.Backend.Name(), "dryRun", dryRun)
+	err = r.UpdateConfigMap(ctx, snippet)
+	if dryRun && err == nil {
+		setDryRunCondition(snippet)
+	} else {
+		setSyncedCondition(snippet, err)
+	}
+	if err != nil {
+		logger.Error(err, "Failed to update mappings", "backend", r.Backend.Name())
 		return ctrl.Result{}, err
 	}
 
 	logger.Info("Reconciliation completed")
-	snippet.Status.IsSynced = true
-
 	return ctrl.Result{}, nil
 }
 
 /*
-UpdateSnippetStatus stores the ARNS that are being managed in the status
-sub-object und updates the status.
+UpdateSnippetStatus stores the ARNs and account IDs that are being managed in
+the status sub-object, derives the Ready condition from the other conditions
+and updates the status.
+
+In dry-run mode and for invalid specs the ARNs and account IDs are kept as
+they are, since the mappings of the spec were not written.
 */
-func (r *AwsAuthMapSnippetReconciler) UpdateSnippetStatus(ctx context.Context, current, original *crdv1beta1.AwsAuthMapSnippet) error {
+func (r *AwsAuthMapSnippetReconciler) UpdateSnippetStatus(ctx context.Context, current, original Snippet) error {
+	spec, status := current.GetSpec(), current.GetStatus()
+	status.ObservedGeneration = current.GetGeneration()
+	setReadyCondition(current)
+	if r.isDryRun(current) || len(spec.Validate(field.NewPath("spec"))) > 0 {
+		return r.Status().Patch(ctx, current, client.MergeFrom(original))
+	}
 
 	// Overwrite lists with current
-	current.Status.RoleArns = []string{}
-	current.Status.UserArns = []string{}
+	status.RoleArns = []string{}
+	status.UserArns = []string{}
+	status.Accounts = []string{}
 
-	for _, mr := range current.Spec.MapRoles {
-		current.Status.RoleArns = append(current.Status.RoleArns, mr.RoleArn)
+	for _, mr := range spec.MapRoles {
+		status.RoleArns = append(status.RoleArns, mr.RoleArn)
 	}
-	for _, mu := range current.Spec.MapUsers {
-		current.Status.UserArns = append(current.Status.UserArns, mu.UserArn)
+	for _, mu := range spec.MapUsers {
+		status.UserArns = append(status.UserArns, mu.UserArn)
 	}
+	for _, ma := range spec.MapAccounts {
+		status.Accounts = append(status.Accounts, string(ma))
+	}
+
 	return r.Status().Patch(ctx, current, client.MergeFrom(original))
 }
 
 /*
-UpdateConfigMap removes obsolete entries from the ConfigMap and updates all
-others.
+UpdateConfigMap recomputes all managed entries of the backend from the
+snippets and applies the result.
 
-This also covers creation of new entries.
+This also covers creation of new entries and removal of obsolete ones.
 */
-func (r *AwsAuthMapSnippetReconciler) UpdateConfigMap(ctx context.Context, snippet *crdv1beta1.AwsAuthMapSnippet, awsauth *AwsAuthMap) error {
-
-	// find entries that need to be deleted
-	// (present in status, missing in spec)
-	for _, ra := range snippet.Status.RoleArns {
-		found := false
-		for _, role := range snippet.Spec.MapRoles {
-			if role.RoleArn == ra {
-				found = true
-				break
-			}
+func (r *AwsAuthMapSnippetReconciler) UpdateConfigMap(ctx context.Context, snippet Snippet) error {
+	return r.syncConfigMap(ctx, snippet)
+}
+
+/*
+CleanUpConfigMap removes all ARN and account mappings from the backend that
+were managed by this snippet.
+
+The snippet is expected to be marked for deletion so that it no longer
+contributes to the desired state.
+*/
+func (r *AwsAuthMapSnippetReconciler) CleanUpConfigMap(ctx context.Context, snippet Snippet) error {
+	return r.syncConfigMap(ctx, snippet)
+}
+
+/*
+SyncConfigMap performs a full recompute of the backend from all snippets.
+
+It is run once at startup so that the backend converges even if no snippet
+is reconciled, and then every ResyncPeriod. Errors are only logged because the
+regular reconciliation will catch up anyway.
+*/
+func (r *AwsAuthMapSnippetReconciler) SyncConfigMap(ctx context.Context) error {
+	r.syncOnce(ctx)
+	if r.Options.ResyncPeriod <= 0 {
+		return nil
+	}
+
+	ticker := time.NewTicker(r.Options.ResyncPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			r.syncOnce(ctx)
 		}
-		if !found {
-			delete(*awsauth.Roles, ra)
+	}
+}
+
+func (r *AwsAuthMapSnippetReconciler) syncOnce(ctx context.Context) {
+	logger := log.FromContext(ctx)
+	if restore, err := r.pausedBy(ctx); err != nil || restore != "" {
+		logger.Info("Skipping full sync, reconciliation is paused", "restore", restore, "error", err)
+		return
+	}
+	logger.Info("Performing full sync", "backend", r.Backend.Name())
+
+	if err := r.syncConfigMap(ctx, nil); err != nil {
+		logger.Error(err, "Full sync failed", "backend", r.Backend.Name())
+	}
+}
+
+/*
+syncConfigMap lists all snippets and applies the resulting desired state to the
+backend, which merges it with the entries that were never managed.
+
+If snippet is given it replaces its counterpart from the list, as it might be
+more recent than the cached version.
+
+Snippets in dry-run mode are left out, so that their entries are neither
+written nor removed. If the given snippet itself is in dry-run mode, or the
+controller is, the changes are only reported.
+*/
+func (r *AwsAuthMapSnippetReconciler) syncConfigMap(ctx context.Context, snippet Snippet) error {
+	snippets, err := r.listSnippets(ctx, snippet)
+	if err != nil {
+		return err
+	}
+	if snippet != nil {
+		ctx = WithTrigger(ctx, snippetKey(snippet))
+		previous := append([]metav1.Condition(nil), snippet.GetStatus().Conditions...)
+		defer r.recordConditionEvents(snippet, previous)
+	}
+	if !r.Options.DryRun {
+		snippets = withoutDryRunSnippets(snippets, snippet)
+	}
+	desired := r.desiredState(snippets)
+	if snippet != nil {
+		setInvalidCondition(snippet)
+		setConflictCondition(snippet, desired.Conflicts[snippetKey(snippet)])
+		setRejectedCondition(snippet, r.Options.ProtectedArns.Declared(snippet), nil)
+	}
+
+	if r.Options.DryRun || snippet != nil && r.isDryRun(snippet) {
+		return r.dryRunDesiredState(ctx, snippet, desired)
+	}
+	if snippet != nil {
+		snippet.GetStatus().DryRunDiff = ""
+	}
+
+	results := r.applyDesiredState(ctx, desired)
+	if results[0].Changes != nil && results[0].Changes.Mappings != nil {
+		r.rememberMappings(results[0].Changes.Mappings)
+	}
+	for _, result := range results {
+		if result.Backend == BACKEND_CONFIGMAP && result.Err == nil {
+			r.countDriftCorrections(result.Changes)
 		}
 	}
-	for _, ua := range snippet.Status.UserArns {
-		found := false
-		for _, user := range snippet.Spec.MapUsers {
-			if user.UserArn == ua {
-				found = true
-				break
-			}
+	var blockedReasons []string
+	if snippet != nil {
+		setBackendStatus(snippet, results)
+		blockedReasons = setBlockedCondition(snippet, results)
+		setRejectedCondition(snippet, r.Options.ProtectedArns.Declared(snippet), results)
+	}
+	if len(results) > 1 {
+		r.checkDivergence(snippet, results, desired)
+	}
+
+	logger := log.FromContext(ctx)
+	errs := []error{}
+	for _, result := range results {
+		if result.Changes != nil && len(result.Changes.Blocked) > 0 {
+			logger.Info("Lock-out protection kept entries", "backend", result.Backend, "blocked", result.Changes.Blocked)
 		}
-		if !found {
-			delete(*awsauth.Users, ua)
+		if result.Err == nil {
+			continue
+		}
+		logger.Error(result.Err, "Error applying mappings", "backend", result.Backend)
+		var blocked *BlockedError
+		if snippet != nil && errors.As(result.Err, &blocked) {
+			r.Recorder.Event(snippet, corev1.EventTypeWarning, EventReasonBlocked,
+				fmt.Sprintf("Refused to write mappings to backend %s: %s", result.Backend, strings.Join(blocked.Reasons, "; ")))
+		} else if snippet != nil {
+			r.Recorder.Event(snippet, corev1.EventTypeWarning, EventReasonWriteFailed,
+				fmt.Sprintf("Failed to write mappings to backend %s: %s", result.Backend, result.Err))
 		}
+		errs = append(errs, result.Err)
 	}
-
-	// Update or create ARN mappings in the ConfigMap
-	for _, mr := range snippet.Spec.MapRoles {
-		(*awsauth.Roles)[mr.RoleArn] = mr
+	if len(errs) > 0 {
+		return utilerrors.NewAggregate(errs)
 	}
-	for _, mu := range snippet.Spec.MapUsers {
-		(*awsauth.Users)[mu.UserArn] = mu
+	r.recordChanges(snippets, desired, results[0].Changes)
+	if snippet == nil {
+		return nil
 	}
+	setPendingRestores(snippet, results)
+	if len(blockedReasons) > 0 {
+		// The entries of other snippets were written, but this one keeps
+		// failing, and keeps its finalizer, until the conflict is resolved.
+		r.Recorder.Event(snippet, corev1.EventTypeWarning, EventReasonBlocked,
+			"Refused to change mappings by lock-out protection, "+strings.Join(blockedReasons, "; "))
+		return &BlockedError{Reasons: blockedReasons}
+	}
+	return nil
+}
+
+// desiredState computes the desired state of the snippets without the
+// protected ARNs.
+func (r *AwsAuthMapSnippetReconciler) desiredState(snippets []Snippet) *DesiredState {
+	desired := ComputeDesiredState(snippets)
+	desired.Protect(r.Options.ProtectedArns)
+	return desired
+}
+
+// applyDesiredState applies the desired state to every backend and returns
+// the individual results.
+func (r *AwsAuthMapSnippetReconciler) applyDesiredState(ctx context.Context, desired *DesiredState) []BackendResult {
+	if composite, ok := r.Backend.(*CompositeBackend); ok {
+		return composite.ApplyEach(ctx, desired)
+	}
+	changes, err := r.Backend.Apply(ctx, desired)
+	return []BackendResult{{Backend: r.Backend.Name(), Changes: changes, Err: err}}
+}
 
-	// Write the updated ConfigMap to the API
-	err := awsauth.Write(ctx)
+/*
+dryRunDesiredState computes the mappings that would result from the desired
+state and reports the diff of mapRoles and mapUsers in the log and, if
+snippet is given, in an event and the status of the snippet. The backend is
+not modified.
+*/
+func (r *AwsAuthMapSnippetReconciler) dryRunDesiredState(ctx context.Context, snippet Snippet, desired *DesiredState) error {
+	current, err := r.Backend.Load(ctx)
+	if err != nil {
+		return err
+	}
+	r.rememberMappings(current)
+	proposed := current.Copy()
+	proposed.Merge(desired)
+	diff, err := MappingsDiff(current, proposed)
 	if err != nil {
-		logger := log.FromContext(ctx)
-		logger.Error(err, "Error updating aws-auth ConfigMap")
 		return err
 	}
+
+	logger := log.FromContext(ctx)
+	logger.Info("Dry run, not writing mappings", "backend", r.Backend.Name(), "diff", diff)
+	if snippet != nil {
+		snippet.GetStatus().DryRunDiff = diff
+		r.Recorder.Event(snippet, corev1.EventTypeNormal, EventReasonDryRun, dryRunEventMessage(diff))
+	}
 	return nil
 }
 
 /*
-CleanUpConfigMap removes all ARN mappings from the ConfigMap that were managed
-by this snippet.
+pausedBy returns the name of an AwsAuthMapRestore that pauses the snippet
+reconciliation, or an empty string if the reconciliation is not paused.
 */
-func (r *AwsAuthMapSnippetReconciler) CleanUpConfigMap(ctx context.Context, snippet *crdv1beta1.AwsAuthMapSnippet, awsauth *AwsAuthMap) error {
-	for _, ra := range snippet.Status.RoleArns {
-		delete(*awsauth.Roles, ra)
+func (r *AwsAuthMapSnippetReconciler) pausedBy(ctx context.Context) (string, error) {
+	restores := &crdv1beta1.AwsAuthMapRestoreList{}
+	if err := r.List(ctx, restores); err != nil {
+		return "", err
 	}
-	for _, ua := range snippet.Status.UserArns {
-		delete(*awsauth.Users, ua)
+	for _, restore := range restores.Items {
+		if restore.Pauses() {
+			return restore.Name, nil
+		}
 	}
+	return "", nil
+}
 
-	// Write the updated ConfigMap to the API
-	err := awsauth.Write(ctx)
-	if err != nil {
-		logger := log.FromContext(ctx)
-		logger.Error(err, "Error updating aws-auth ConfigMap")
-		return err
+// isDryRun returns true if the changes of the snippet are only reported, by
+// the controller's option or the annotation of the snippet.
+func (r *AwsAuthMapSnippetReconciler) isDryRun(snippet Snippet) bool {
+	return r.Options.DryRun || snippet.GetAnnotations()[DRY_RUN_ANNOTATION] == "true"
+}
+
+// withoutDryRunSnippets returns the snippets that do not have the dry-run
+// annotation. The current snippet, if given, is always kept.
+func withoutDryRunSnippets(snippets []Snippet, current Snippet) []Snippet {
+	result := []Snippet{}
+	for _, s := range snippets {
+		if s.GetAnnotations()[DRY_RUN_ANNOTATION] != "true" ||
+			current != nil && snippetKey(s) == snippetKey(current) {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+/*
+checkDivergence compares the results of the backends of a CompositeBackend,
+updates the divergence metric and reports the diverged ARNs of the snippet, if
+given, in its Diverged condition.
+*/
+func (r *AwsAuthMapSnippetReconciler) checkDivergence(snippet Snippet, results []BackendResult, desired *DesiredState) {
+	divergence := Divergence(results, desired)
+	for backend, arns := range divergence {
+		backendDivergence.WithLabelValues(backend).Set(float64(len(arns)))
+	}
+	if snippet != nil {
+		setDivergedCondition(snippet, divergence, desired)
 	}
-	return nil
+}
+
+/*
+listSnippets returns all cluster-scoped snippets and all namespaced snippets
+from the watched namespaces that are of the controller class.
+
+If current is given it replaces its counterpart from the list.
+*/
+func (r *AwsAuthMapSnippetReconciler) listSnippets(ctx context.Context, current Snippet) ([]Snippet, error) {
+	snippetList := &crdv1beta1.AwsAuthMapSnippetList{}
+	if err := r.List(ctx, snippetList); err != nil {
+		return nil, err
+	}
+	clusterSnippetList := &crdv1beta1.ClusterAwsAuthMapSnippetList{}
+	if err := r.List(ctx, clusterSnippetList); err != nil {
+		return nil, err
+	}
+
+	snippets := []Snippet{}
+	for i := range snippetList.Items {
+		if predicates.InNamespaces(r.Options.Namespaces, snippetList.Items[i].Namespace) &&
+			predicates.HasControllerClass(r.Options.ControllerClass, &snippetList.Items[i]) {
+			snippets = append(snippets, &snippetList.Items[i])
+		}
+	}
+	for i := range clusterSnippetList.Items {
+		if predicates.HasControllerClass(r.Options.ControllerClass, &clusterSnippetList.Items[i]) {
+			snippets = append(snippets, &clusterSnippetList.Items[i])
+		}
+	}
+
+	if current == nil {
+		return snippets, nil
+	}
+	for i, s := range snippets {
+		if snippetKey(s) == snippetKey(current) {
+			snippets[i] = current
+			return snippets, nil
+		}
+	}
+	return append(snippets, current), nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *AwsAuthMapSnippetReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&crdv1beta1.AwsAuthMapSnippet{}).
-		WithEventFilter(predicates.NamespaceFilter(r.Options.Namespaces)).
-		Complete(r)
+	if r.Backend == nil {
+		r.Backend = &ConfigMapBackend{Client: r.Client}
+	}
+
+	namespaceFilter := predicates.NamespaceFilter(r.Options.Namespaces)
+	classFilter := predicates.ControllerClassFilter(r.Options.ControllerClass)
+	b := ctrl.NewControllerManagedBy(mgr).
+		// Ignore updates of the status, the controller writes it itself.
+		For(&crdv1beta1.AwsAuthMapSnippet{}, builder.WithPredicates(namespaceFilter, classFilter, specChangedPredicate)).
+		// Snippets that declare the same ARNs need to re-evaluate their
+		// conflicts, e.g. to take over an ARN from a deleted snippet.
+		Watches(
+			&source.Kind{Type: &crdv1beta1.AwsAuthMapSnippet{}},
+			handler.EnqueueRequestsFromMapFunc(r.findConflictingSnippets(false)),
+			builder.WithPredicates(namespaceFilter, classFilter, predicate.GenerationChangedPredicate{}),
+		).
+		Watches(
+			&source.Kind{Type: &crdv1beta1.ClusterAwsAuthMapSnippet{}},
+			handler.EnqueueRequestsFromMapFunc(r.findConflictingSnippets(false)),
+			builder.WithPredicates(classFilter, predicate.GenerationChangedPredicate{}),
+		)
+	r.watchConfigMap(b, false)
+	if err := b.Complete(r); err != nil {
+		return err
+	}
+
+	if err := metrics.Registry.Register(&stateCollector{r}); err != nil {
+		return err
+	}
+
+	// Converge the backend at startup and periodically.
+	if err := mgr.Add(manager.RunnableFunc(r.SyncConfigMap)); err != nil {
+		return err
+	}
+	// Orphans and unmanaged entries are found by the ownership index of the
+	// ConfigMap.
+	if r.configMapBackend() == nil {
+		return nil
+	}
+	if r.Options.GenerateSnippetsNamespace != "" {
+		if err := mgr.Add(manager.RunnableFunc(r.GenerateSnippets)); err != nil {
+			return err
+		}
+	}
+	return mgr.Add(manager.RunnableFunc(r.SweepOrphans))
+}
+
+/*
+watchConfigMap restores managed entries if the ConfigMap is modified by
+others. This only applies if the mappings are stored in the ConfigMap.
+*/
+func (r *AwsAuthMapSnippetReconciler) watchConfigMap(b *builder.Builder, clusterScoped bool) {
+	cmb := r.configMapBackend()
+	if cmb == nil {
+		return
+	}
+	key := cmb.Key()
+	b.Watches(
+		&source.Kind{Type: &corev1.ConfigMap{}},
+		handler.EnqueueRequestsFromMapFunc(r.findDriftedSnippets(clusterScoped)),
+		builder.WithPredicates(predicates.ObjectFilter(key.Namespace, key.Name)),
+	)
+}
+
+// configMapBackend returns the backend if it is a ConfigMapBackend, or the
+// first ConfigMapBackend of a CompositeBackend, or nil otherwise.
+func (r *AwsAuthMapSnippetReconciler) configMapBackend() *ConfigMapBackend {
+	if composite, ok := r.Backend.(*CompositeBackend); ok {
+		for _, backend := range composite.Backends {
+			if cmb, ok := backend.(*ConfigMapBackend); ok {
+				return cmb
+			}
+		}
+	}
+	cmb, _ := r.Backend.(*ConfigMapBackend)
+	return cmb
+}
+
+// primaryBackend returns the backend whose content is reported by the
+// metrics, the first one of a CompositeBackend.
+func (r *AwsAuthMapSnippetReconciler) primaryBackend() Backend {
+	if composite, ok := r.Backend.(*CompositeBackend); ok {
+		return composite.Backends[0]
+	}
+	return r.Backend
+}
+
+/*
+findConflictingSnippets returns a MapFunc that creates requests for all other
+snippets that declare at least one of the ARNs of the given snippet.
+
+Only snippets of the kind handled by the calling controller are returned,
+cluster-scoped ones if clusterScoped is set and namespaced ones otherwise.
+*/
+func (r *AwsAuthMapSnippetReconciler) findConflictingSnippets(clusterScoped bool) handler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		snippet, ok := obj.(Snippet)
+		if !ok {
+			return nil
+		}
+		snippets, err := r.listSnippets(context.Background(), nil)
+		if err != nil {
+			log.Log.Error(err, "Failed to list snippets")
+			return nil
+		}
+
+		arns := map[string]bool{}
+		for _, mr := range snippet.GetSpec().MapRoles {
+			arns[mr.RoleArn] = true
+		}
+		for _, mu := range snippet.GetSpec().MapUsers {
+			arns[mu.UserArn] = true
+		}
+
+		requests := []reconcile.Request{}
+		for _, other := range snippets {
+			if snippetKey(other) == snippetKey(snippet) || (other.GetNamespace() == "") != clusterScoped {
+				continue
+			}
+			shared := false
+			for _, mr := range other.GetSpec().MapRoles {
+				shared = shared || arns[mr.RoleArn]
+			}
+			for _, mu := range other.GetSpec().MapUsers {
+				shared = shared || arns[mu.UserArn]
+			}
+			if shared {
+				requests = append(requests, reconcile.Request{
+					NamespacedName: client.ObjectKeyFromObject(other),
+				})
+			}
+		}
+		return requests
+	}
+}
+
+/*
+findDriftedSnippets returns a MapFunc that creates requests for all snippets
+whose entries are missing from the ConfigMap or were modified.
+
+Only snippets of the kind handled by the calling controller are returned,
+cluster-scoped ones if clusterScoped is set and namespaced ones otherwise.
+*/
+func (r *AwsAuthMapSnippetReconciler) findDriftedSnippets(clusterScoped bool) handler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		cm, ok := obj.(*corev1.ConfigMap)
+		if !ok {
+			return nil
+		}
+		current, err := r.configMapBackend().Parse(cm)
+		if err != nil {
+			log.Log.Error(err, "Failed to parse ConfigMap")
+			return nil
+		}
+		if r.Options.DryRun {
+			return nil
+		}
+		if restore, err := r.pausedBy(context.Background()); err != nil || restore != "" {
+			return nil
+		}
+		snippets, err := r.listSnippets(context.Background(), nil)
+		if err != nil {
+			log.Log.Error(err, "Failed to list snippets")
+			return nil
+		}
+		snippets = withoutDryRunSnippets(snippets, nil)
+
+		drift := FindDrift(current, r.desiredState(snippets))
+		requests := []reconcile.Request{}
+		for _, snippet := range snippets {
+			arns := drift[snippetKey(snippet)]
+			if len(arns) == 0 || (snippet.GetNamespace() == "") != clusterScoped {
+				continue
+			}
+			log.Log.Info("Detected drift of aws-auth ConfigMap", "snippet", snippetKey(snippet), "arns", arns)
+			r.markDrifted(arns)
+			requests = append(requests, reconcile.Request{
+				NamespacedName: client.ObjectKeyFromObject(snippet),
+			})
+		}
+		return requests
+	}
 }
 
 // Helper functions to check and remove string from a slice of strings.
@@ -2,18 +2,25 @@ package controllers
 
 import (
 	"context"
+	"strings"
 	"time"
 
 	crdv1beta1 "github.com/inovex/aws-auth-controller/pkg/api/v1beta1"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 	"sigs.k8s.io/yaml"
 )
 
 var _ = Describe("snippet controller", func() {
+	BeforeEach(requireEnvtest)
+
 	It("should update status", func() {
 		const USER_ARN = "arn:aws:iam::123456789012:user/foobar"
 		snip := &crdv1beta1.AwsAuthMapSnippet{
@@ -30,7 +37,6 @@ var _ = Describe("snippet controller", func() {
 					},
 				},
 			},
-			Status: crdv1beta1.AwsAuthMapSnippetStatus{IsSynced: false},
 		}
 		err := k8sClient.Create(context.Background(), snip)
 		Expect(err).ToNot(HaveOccurred())
@@ -44,7 +50,10 @@ var _ = Describe("snippet controller", func() {
 			if err != nil {
 				return false
 			}
-			if !snip.Status.IsSynced {
+			if !meta.IsStatusConditionTrue(snip.Status.Conditions, crdv1beta1.ConditionReady) {
+				return false
+			}
+			if snip.Status.ObservedGeneration != snip.Generation || snip.Status.LastSyncTime == nil {
 				return false
 			}
 			if len(snip.Status.UserArns) != 1 {
@@ -55,7 +64,7 @@ var _ = Describe("snippet controller", func() {
 
 	})
 
-	It("should set isSync to false on failure", func() {
+	It("should set Synced to false on failure", func() {
 		const USER_ARN = "arn:aws:iam::123456789012:user/foobar"
 		snip := &crdv1beta1.AwsAuthMapSnippet{
 			ObjectMeta: metav1.ObjectMeta{
@@ -71,7 +80,6 @@ var _ = Describe("snippet controller", func() {
 					},
 				},
 			},
-			Status: crdv1beta1.AwsAuthMapSnippetStatus{IsSynced: false},
 		}
 		k8sClient.FailUpdateName = "aws-auth"
 		defer func() {
@@ -90,8 +98,10 @@ var _ = Describe("snippet controller", func() {
 			if err != nil {
 				return true
 			}
-			return snip.Status.IsSynced
+			return meta.IsStatusConditionTrue(snip.Status.Conditions, crdv1beta1.ConditionSynced)
 		}, time.Second*10, time.Second).Should(BeFalse())
+		Expect(snip.Status.LastError).ToNot(BeEmpty())
+		Expect(meta.IsStatusConditionTrue(snip.Status.Conditions, crdv1beta1.ConditionReady)).To(BeFalse())
 
 	})
 
@@ -111,7 +121,6 @@ var _ = Describe("snippet controller", func() {
 					},
 				},
 			},
-			Status: crdv1beta1.AwsAuthMapSnippetStatus{IsSynced: false},
 		}
 		err := k8sClient.Create(context.Background(), snip)
 		Expect(err).ToNot(HaveOccurred())
@@ -145,4 +154,538 @@ var _ = Describe("snippet controller", func() {
 			return false
 		}, time.Second*10, time.Second).Should(BeTrue())
 	})
+	It("should restore entries that were removed from the ConfigMap", func() {
+		const USER_ARN = "arn:aws:iam::123456789012:user/restored"
+		const OTHER_USER_ARN = "arn:aws:iam::123456789012:user/other"
+		snip := &crdv1beta1.AwsAuthMapSnippet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "testsnip4",
+				Namespace: "default",
+			},
+			Spec: crdv1beta1.AwsAuthMapSnippetSpec{
+				MapUsers: []crdv1beta1.MapUsersSpec{
+					{
+						UserArn:  USER_ARN,
+						UserName: "restored-name",
+						Groups:   []string{"foobar-group"},
+					},
+				},
+			},
+		}
+		err := k8sClient.Create(context.Background(), snip)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(configMapHasUser(USER_ARN), time.Second*10, time.Second).Should(BeTrue())
+
+		// Remove the entry behind the controller's back
+		awsauth, err := GetAwsAuthMap(k8sClient, context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		delete(*awsauth.Users, USER_ARN)
+		Expect(awsauth.Write(context.Background())).To(Succeed())
+
+		// Any reconcile converges the whole ConfigMap
+		other := &crdv1beta1.AwsAuthMapSnippet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "testsnip5",
+				Namespace: "default",
+			},
+			Spec: crdv1beta1.AwsAuthMapSnippetSpec{
+				MapUsers: []crdv1beta1.MapUsersSpec{
+					{
+						UserArn:  OTHER_USER_ARN,
+						UserName: "other-name",
+						Groups:   []string{"foobar-group"},
+					},
+				},
+			},
+		}
+		err = k8sClient.Create(context.Background(), other)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(configMapHasUser(OTHER_USER_ARN), time.Second*10, time.Second).Should(BeTrue())
+		Eventually(configMapHasUser(USER_ARN), time.Second*10, time.Second).Should(BeTrue())
+	})
+
+	It("should report conflicts and hand over ARNs", func() {
+		const USER_ARN = "arn:aws:iam::123456789012:user/shared"
+		first := &crdv1beta1.AwsAuthMapSnippet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "testsnip6",
+				Namespace: "default",
+			},
+			Spec: crdv1beta1.AwsAuthMapSnippetSpec{
+				MapUsers: []crdv1beta1.MapUsersSpec{
+					{
+						UserArn:  USER_ARN,
+						UserName: "first-name",
+						Groups:   []string{"foobar-group"},
+					},
+				},
+			},
+		}
+		err := k8sClient.Create(context.Background(), first)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(configMapHasUser(USER_ARN), time.Second*10, time.Second).Should(BeTrue())
+
+		// Make sure the second snippet is younger
+		time.Sleep(time.Second)
+		second := &crdv1beta1.AwsAuthMapSnippet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "testsnip7",
+				Namespace: "default",
+			},
+			Spec: crdv1beta1.AwsAuthMapSnippetSpec{
+				MapUsers: []crdv1beta1.MapUsersSpec{
+					{
+						UserArn:  USER_ARN,
+						UserName: "second-name",
+						Groups:   []string{"foobar-group"},
+					},
+				},
+			},
+		}
+		err = k8sClient.Create(context.Background(), second)
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func() bool {
+			err = k8sClient.Get(context.Background(), client.ObjectKeyFromObject(second), second)
+			if err != nil {
+				return false
+			}
+			cond := meta.FindStatusCondition(second.Status.Conditions, crdv1beta1.ConditionConflict)
+			return cond != nil && cond.Status == metav1.ConditionTrue &&
+				strings.Contains(cond.Message, "default/testsnip6")
+		}, time.Second*10, time.Second).Should(BeTrue())
+		Expect(configMapUserName(USER_ARN)()).To(Equal("first-name"))
+
+		// Deleting the winner hands the ARN over to the second snippet
+		Expect(k8sClient.Delete(context.Background(), first)).To(Succeed())
+		Eventually(configMapUserName(USER_ARN), time.Second*10, time.Second).Should(Equal("second-name"))
+		Eventually(func() bool {
+			err = k8sClient.Get(context.Background(), client.ObjectKeyFromObject(second), second)
+			if err != nil {
+				return false
+			}
+			return meta.IsStatusConditionFalse(second.Status.Conditions, crdv1beta1.ConditionConflict)
+		}, time.Second*10, time.Second).Should(BeTrue())
+	})
+
+	It("should retry conflicting ConfigMap writes", func() {
+		const USER_ARN = "arn:aws:iam::123456789012:user/retried"
+		snip := &crdv1beta1.AwsAuthMapSnippet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "testsnip8",
+				Namespace: "default",
+			},
+			Spec: crdv1beta1.AwsAuthMapSnippetSpec{
+				MapUsers: []crdv1beta1.MapUsersSpec{
+					{
+						UserArn:  USER_ARN,
+						UserName: "retried-name",
+						Groups:   []string{"foobar-group"},
+					},
+				},
+			},
+		}
+		conflicts := testutil.ToFloat64(configMapWriteConflicts)
+		k8sClient.ConcurrentUpdateName = CONFIG_MAP_NAME
+		k8sClient.ConcurrentUpdates = 2
+		defer func() {
+			k8sClient.ConcurrentUpdateName = ""
+			k8sClient.ConcurrentUpdates = 0
+		}()
+
+		err := k8sClient.Create(context.Background(), snip)
+		Expect(err).ToNot(HaveOccurred())
+
+		// The retries happen within a single reconciliation, so the status
+		// is synced without waiting for a requeue.
+		Eventually(func() bool {
+			err = k8sClient.Get(context.Background(), client.ObjectKeyFromObject(snip), snip)
+			if err != nil {
+				return false
+			}
+			return meta.IsStatusConditionTrue(snip.Status.Conditions, crdv1beta1.ConditionSynced)
+		}, time.Second*10, time.Second).Should(BeTrue())
+		Expect(configMapHasUser(USER_ARN)()).To(BeTrue())
+		Expect(testutil.ToFloat64(configMapWriteConflicts)).To(BeNumerically(">=", conflicts+2))
+		// The concurrent updates were read again and kept.
+		awsauth, err := GetAwsAuthMap(k8sClient, context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(awsauth.ConfigMap.Annotations).To(HaveKey(CONCURRENT_ANNOTATION))
+	})
+
+	It("should add and remove account mappings", func() {
+		const ACCOUNT_ID = "444455556666"
+		snip := &crdv1beta1.AwsAuthMapSnippet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "testsnip9",
+				Namespace: "default",
+			},
+			Spec: crdv1beta1.AwsAuthMapSnippetSpec{
+				MapAccounts: []crdv1beta1.AccountID{ACCOUNT_ID},
+			},
+		}
+		err := k8sClient.Create(context.Background(), snip)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(configMapHasAccount(ACCOUNT_ID), time.Second*10, time.Second).Should(BeTrue())
+
+		Eventually(func() []string {
+			err = k8sClient.Get(context.Background(), client.ObjectKeyFromObject(snip), snip)
+			if err != nil {
+				return nil
+			}
+			return snip.Status.Accounts
+		}, time.Second*10, time.Second).Should(Equal([]string{ACCOUNT_ID}))
+
+		Expect(k8sClient.Delete(context.Background(), snip)).To(Succeed())
+		Eventually(configMapHasAccount(ACCOUNT_ID), time.Second*10, time.Second).Should(BeFalse())
+	})
+
+	It("should write sorted mappings only when they changed", func() {
+		awsauth, err := GetAwsAuthMap(k8sClient, context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		(*awsauth.Users)["arn:aws:iam::123456789012:user/zzz"] = crdv1beta1.MapUsersSpec{
+			UserArn:  "arn:aws:iam::123456789012:user/zzz",
+			UserName: "zzz",
+			Groups:   []string{"b-group", "a-group"},
+		}
+		(*awsauth.Users)["arn:aws:iam::123456789012:user/aaa"] = crdv1beta1.MapUsersSpec{
+			UserArn:  "arn:aws:iam::123456789012:user/aaa",
+			UserName: "aaa",
+			Groups:   []string{"b-group", "a-group"},
+		}
+		Expect(awsauth.Write(context.Background())).To(Succeed())
+
+		mapUsers := &MapUsers{}
+		err = yaml.Unmarshal([]byte(awsauth.ConfigMap.Data[MAP_USERS_KEY]), mapUsers)
+		Expect(err).ToNot(HaveOccurred())
+		for i := 1; i < len(*mapUsers); i++ {
+			Expect((*mapUsers)[i-1].UserArn < (*mapUsers)[i].UserArn).To(BeTrue())
+		}
+		for _, mu := range *mapUsers {
+			if mu.UserName == "aaa" || mu.UserName == "zzz" {
+				Expect(mu.Groups).To(Equal([]string{"b-group", "a-group"}))
+			}
+		}
+
+		// Writing the same content again does not touch the ConfigMap
+		resourceVersion := awsauth.ConfigMap.ResourceVersion
+		Expect(awsauth.Write(context.Background())).To(Succeed())
+		cm := &corev1.ConfigMap{}
+		err = k8sClient.Get(context.Background(), types.NamespacedName{
+			Name:      CONFIG_MAP_NAME,
+			Namespace: CONFIG_MAP_NAMESPACE,
+		}, cm)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cm.ResourceVersion).To(Equal(resourceVersion))
+	})
+
+	It("should let cluster-scoped snippets take precedence", func() {
+		const ROLE_ARN = "arn:aws:iam::123456789012:role/platform"
+		snip := &crdv1beta1.AwsAuthMapSnippet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "testsnip10",
+				Namespace: "default",
+			},
+			Spec: crdv1beta1.AwsAuthMapSnippetSpec{
+				MapRoles: []crdv1beta1.MapRolesSpec{
+					{
+						RoleArn:  ROLE_ARN,
+						UserName: "tenant-name",
+						Groups:   []string{"foobar-group"},
+					},
+				},
+			},
+		}
+		err := k8sClient.Create(context.Background(), snip)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(configMapRoleUserName(ROLE_ARN), time.Second*10, time.Second).Should(Equal("tenant-name"))
+
+		clusterSnip := &crdv1beta1.ClusterAwsAuthMapSnippet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "testclustersnip",
+			},
+			Spec: crdv1beta1.AwsAuthMapSnippetSpec{
+				MapRoles: []crdv1beta1.MapRolesSpec{
+					{
+						RoleArn:  ROLE_ARN,
+						UserName: "platform-name",
+						Groups:   []string{"foobar-group"},
+					},
+				},
+			},
+		}
+		err = k8sClient.Create(context.Background(), clusterSnip)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(configMapRoleUserName(ROLE_ARN), time.Second*10, time.Second).Should(Equal("platform-name"))
+
+		Eventually(func() bool {
+			err = k8sClient.Get(context.Background(), client.ObjectKeyFromObject(snip), snip)
+			if err != nil {
+				return false
+			}
+			return meta.IsStatusConditionTrue(snip.Status.Conditions, crdv1beta1.ConditionConflict)
+		}, time.Second*10, time.Second).Should(BeTrue())
+
+		Expect(k8sClient.Delete(context.Background(), clusterSnip)).To(Succeed())
+		Eventually(configMapRoleUserName(ROLE_ARN), time.Second*10, time.Second).Should(Equal("tenant-name"))
+	})
+
+	It("should not apply invalid snippets", func() {
+		const ROLE_ARN = "arn:aws:iam::123456789012:role/invalid"
+		snip := &crdv1beta1.AwsAuthMapSnippet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "testsnip12",
+				Namespace: "default",
+			},
+			Spec: crdv1beta1.AwsAuthMapSnippetSpec{
+				MapRoles: []crdv1beta1.MapRolesSpec{
+					{
+						RoleArn:  ROLE_ARN,
+						UserName: "invalid-name",
+						Groups:   []string{},
+					},
+				},
+			},
+		}
+		err := k8sClient.Create(context.Background(), snip)
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func() bool {
+			err = k8sClient.Get(context.Background(), client.ObjectKeyFromObject(snip), snip)
+			if err != nil {
+				return false
+			}
+			return meta.IsStatusConditionTrue(snip.Status.Conditions, crdv1beta1.ConditionInvalid)
+		}, time.Second*10, time.Second).Should(BeTrue())
+		ready := meta.FindStatusCondition(snip.Status.Conditions, crdv1beta1.ConditionReady)
+		Expect(ready).ToNot(BeNil())
+		Expect(ready.Status).To(Equal(metav1.ConditionFalse))
+		Expect(ready.Reason).To(Equal(crdv1beta1.ReasonValidationFailed))
+		Expect(configMapRoleUserName(ROLE_ARN)()).To(BeEmpty())
+	})
+
+	It("should expose metrics about the mappings", func() {
+		const ROLE_ARN = "arn:aws:iam::123456789012:role/metrics"
+		snip := &crdv1beta1.AwsAuthMapSnippet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "testsnip13",
+				Namespace: "default",
+			},
+			Spec: crdv1beta1.AwsAuthMapSnippetSpec{
+				MapRoles: []crdv1beta1.MapRolesSpec{
+					{
+						RoleArn:  ROLE_ARN,
+						UserName: "metrics-name",
+						Groups:   []string{"foobar-group"},
+					},
+				},
+			},
+		}
+		attempts := testutil.ToFloat64(configMapWriteAttempts)
+		err := k8sClient.Create(context.Background(), snip)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(configMapRoleUserName(ROLE_ARN), time.Second*10, time.Second).Should(Equal("metrics-name"))
+		Expect(testutil.ToFloat64(configMapWriteAttempts)).To(BeNumerically(">", attempts))
+
+		families, err := metrics.Registry.Gather()
+		Expect(err).ToNot(HaveOccurred())
+		found := false
+		for _, family := range families {
+			if family.GetName() != "awsauth_mapping_info" {
+				continue
+			}
+			for _, m := range family.GetMetric() {
+				labels := map[string]string{}
+				for _, l := range m.GetLabel() {
+					labels[l.GetName()] = l.GetValue()
+				}
+				found = found || labels["arn"] == ROLE_ARN &&
+					labels["username"] == "metrics-name" &&
+					labels["namespace"] == "default" &&
+					labels["snippet"] == "testsnip13"
+			}
+		}
+		Expect(found).To(BeTrue())
+		count, err := testutil.GatherAndCount(metrics.Registry, "awsauth_configmap_bytes")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(Equal(1))
+	})
+
+	It("should record events for changed mappings", func() {
+		const USER_ARN = "arn:aws:iam::123456789012:user/events"
+		snip := &crdv1beta1.AwsAuthMapSnippet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "testsnip14",
+				Namespace: "default",
+			},
+			Spec: crdv1beta1.AwsAuthMapSnippetSpec{
+				MapUsers: []crdv1beta1.MapUsersSpec{
+					{
+						UserArn:  USER_ARN,
+						UserName: "events-name",
+						Groups:   []string{"foobar-group"},
+					},
+				},
+			},
+		}
+		err := k8sClient.Create(context.Background(), snip)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(snippetEventMessage(snip, EventReasonMappingsAdded), time.Second*10, time.Second).
+			Should(ContainSubstring(USER_ARN))
+
+		Expect(k8sClient.Delete(context.Background(), snip)).To(Succeed())
+		Eventually(snippetEventMessage(snip, EventReasonMappingsRemoved), time.Second*10, time.Second).
+			Should(ContainSubstring(USER_ARN))
+		Eventually(snippetEventMessage(snip, EventReasonFinalized), time.Second*10, time.Second).
+			ShouldNot(BeEmpty())
+	})
+
+	It("should heal drift of the ConfigMap", func() {
+		const USER_ARN = "arn:aws:iam::123456789012:user/drifted"
+		snip := &crdv1beta1.AwsAuthMapSnippet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "testsnip11",
+				Namespace: "default",
+			},
+			Spec: crdv1beta1.AwsAuthMapSnippetSpec{
+				MapUsers: []crdv1beta1.MapUsersSpec{
+					{
+						UserArn:  USER_ARN,
+						UserName: "drifted-name",
+						Groups:   []string{"foobar-group"},
+					},
+				},
+			},
+		}
+		err := k8sClient.Create(context.Background(), snip)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(configMapUserName(USER_ARN), time.Second*10, time.Second).Should(Equal("drifted-name"))
+
+		corrections := testutil.ToFloat64(driftCorrections)
+
+		// Modify the entry behind the controller's back
+		awsauth, err := GetAwsAuthMap(k8sClient, context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		mu := (*awsauth.Users)[USER_ARN]
+		mu.UserName = "modified-name"
+		(*awsauth.Users)[USER_ARN] = mu
+		Expect(awsauth.Write(context.Background())).To(Succeed())
+
+		// The ConfigMap watch restores it without touching any snippet
+		Eventually(configMapUserName(USER_ARN), time.Second*10, time.Second).Should(Equal("drifted-name"))
+		Expect(testutil.ToFloat64(driftCorrections)).To(BeNumerically(">", corrections))
+	})
+
+	It("should only report the changes of dry-run snippets", func() {
+		const USER_ARN = "arn:aws:iam::123456789012:user/dry-run"
+		snip := &crdv1beta1.AwsAuthMapSnippet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "testsnip15",
+				Namespace:   "default",
+				Annotations: map[string]string{DRY_RUN_ANNOTATION: "true"},
+			},
+			Spec: crdv1beta1.AwsAuthMapSnippetSpec{
+				MapUsers: []crdv1beta1.MapUsersSpec{
+					{
+						UserArn:  USER_ARN,
+						UserName: "dry-run",
+						Groups:   []string{"foobar-group"},
+					},
+				},
+			},
+		}
+		err := k8sClient.Create(context.Background(), snip)
+		Expect(err).ToNot(HaveOccurred())
+
+		key := client.ObjectKeyFromObject(snip)
+		Eventually(func() string {
+			if err := k8sClient.Get(context.Background(), key, snip); err != nil {
+				return ""
+			}
+			return snip.Status.DryRunDiff
+		}, time.Second*10, time.Second).Should(ContainSubstring("+  userarn: " + USER_ARN))
+		Expect(snippetEventMessage(snip, EventReasonDryRun)()).To(ContainSubstring(USER_ARN))
+		Expect(configMapHasUser(USER_ARN)()).To(BeFalse())
+		Expect(snip.Finalizers).To(BeEmpty())
+		Expect(snip.Status.UserArns).To(BeEmpty())
+
+		// Removing the annotation applies the snippet
+		delete(snip.Annotations, DRY_RUN_ANNOTATION)
+		Expect(k8sClient.Update(context.Background(), snip)).To(Succeed())
+		Eventually(configMapHasUser(USER_ARN), time.Second*10, time.Second).Should(BeTrue())
+		Eventually(func() string {
+			if err := k8sClient.Get(context.Background(), key, snip); err != nil {
+				return "error"
+			}
+			return snip.Status.DryRunDiff
+		}, time.Second*10, time.Second).Should(BeEmpty())
+	})
 })
+
+// snippetEventMessage returns a function that returns the message of the
+// latest event with the given reason that was recorded on the snippet.
+func snippetEventMessage(snippet client.Object, reason string) func() string {
+	return func() string {
+		events := &corev1.EventList{}
+		err := k8sClient.List(context.Background(), events, client.InNamespace(snippet.GetNamespace()))
+		if err != nil {
+			return ""
+		}
+		message := ""
+		for _, event := range events.Items {
+			if event.InvolvedObject.Name == snippet.GetName() && event.Reason == reason {
+				message = event.Message
+			}
+		}
+		return message
+	}
+}
+
+// configMapRoleUserName returns a function that returns the user name mapped
+// to the given role ARN in the ConfigMap.
+func configMapRoleUserName(roleArn string) func() string {
+	return func() string {
+		awsauth, err := GetAwsAuthMap(k8sClient, context.Background())
+		if err != nil {
+			return ""
+		}
+		return (*awsauth.Roles)[roleArn].UserName
+	}
+}
+
+// configMapHasAccount returns a function that checks if the ConfigMap
+// contains a mapping for the given account ID.
+func configMapHasAccount(accountID string) func() bool {
+	return func() bool {
+		awsauth, err := GetAwsAuthMap(k8sClient, context.Background())
+		if err != nil {
+			return false
+		}
+		return (*awsauth.Accounts)[accountID]
+	}
+}
+
+// configMapUserName returns a function that returns the user name mapped to
+// the given user ARN in the ConfigMap.
+func configMapUserName(userArn string) func() string {
+	return func() string {
+		awsauth, err := GetAwsAuthMap(k8sClient, context.Background())
+		if err != nil {
+			return ""
+		}
+		return (*awsauth.Users)[userArn].UserName
+	}
+}
+
+// configMapHasUser returns a function that checks if the ConfigMap contains
+// a mapping for the given user ARN.
+func configMapHasUser(userArn string) func() bool {
+	return func() bool {
+		awsauth, err := GetAwsAuthMap(k8sClient, context.Background())
+		if err != nil {
+			return false
+		}
+		_, ok := (*awsauth.Users)[userArn]
+		return ok
+	}
+}
@@ -0,0 +1,228 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+/*
+Backend is a store of identity mappings that the snippets are applied to.
+
+The reconciler computes the desired state from all snippets and hands it to
+the backend, which merges it with the entries that are not managed by any
+snippet.
+*/
+type Backend interface {
+	// Name identifies the backend in logs, events and metrics.
+	Name() string
+	// Load returns the mappings that are currently stored.
+	Load(ctx context.Context) (*Mappings, error)
+	// Apply replaces all managed mappings with the desired state and returns
+	// the entries that were changed.
+	Apply(ctx context.Context, desired *DesiredState) (*Changes, error)
+}
+
+// Names of the available backends, as used by the --backend flag.
+const (
+	BACKEND_CONFIGMAP         = "configmap"
+	BACKEND_MEMORY            = "memory"
+	BACKEND_ACCESS_ENTRIES    = "accessentries"
+	BACKEND_IDENTITY_MAPPINGS = "iamidentitymappings"
+	BACKEND_FILE              = "file"
+)
+
+// BackendOptions holds the dependencies of the backends. Only the ones needed
+// by the chosen backend have to be set.
+type BackendOptions struct {
+	// Client is used by the configmap and iamidentitymappings backends.
+	Client client.Client
+	// Reader is an uncached reader that the configmap backend uses after
+	// conflicting writes.
+	Reader client.Reader
+	// ConfigMapKey is the ConfigMap of the configmap backend, the aws-auth
+	// ConfigMap if not set.
+	ConfigMapKey client.ObjectKey
+	// History stores snapshots of the ConfigMap of the configmap backend.
+	History *History
+	// Protection guards the writes of the configmap backend.
+	Protection *LockoutProtection
+
+	// EKSClient and ClusterName are used by the accessentries backend.
+	EKSClient   EKSClient
+	ClusterName string
+
+	// FilePath is the authenticator config file of the file backend.
+	FilePath string
+}
+
+/*
+NewBackend returns the backend with the given name.
+
+If several names are given, separated by commas, a CompositeBackend is returned
+that writes to all of them. The first one is the primary backend.
+*/
+func NewBackend(name string, opts BackendOptions) (Backend, error) {
+	if names := strings.Split(name, ","); len(names) > 1 {
+		composite := &CompositeBackend{}
+		for _, n := range names {
+			backend, err := NewBackend(n, opts)
+			if err != nil {
+				return nil, err
+			}
+			composite.Backends = append(composite.Backends, backend)
+		}
+		return composite, nil
+	}
+
+	switch name {
+	case BACKEND_CONFIGMAP:
+		return &ConfigMapBackend{Client: opts.Client, Reader: opts.Reader, ConfigMapKey: opts.ConfigMapKey, History: opts.History, Protection: opts.Protection}, nil
+	case BACKEND_MEMORY:
+		return NewInMemoryBackend(), nil
+	case BACKEND_ACCESS_ENTRIES:
+		if opts.EKSClient == nil || opts.ClusterName == "" {
+			return nil, fmt.Errorf("backend %s needs an EKS client and a cluster name", name)
+		}
+		return &AccessEntriesBackend{Client: opts.EKSClient, ClusterName: opts.ClusterName}, nil
+	case BACKEND_IDENTITY_MAPPINGS:
+		return &IdentityMappingsBackend{Client: opts.Client}, nil
+	case BACKEND_FILE:
+		if opts.FilePath == "" {
+			return nil, fmt.Errorf("backend %s needs a file path", name)
+		}
+		return &FileBackend{Path: opts.FilePath}, nil
+	default:
+		return nil, fmt.Errorf("unknown backend %q", name)
+	}
+}
+
+// UsesBackend returns true if the backend with the given name is one of the
+// comma-separated names as passed to NewBackend.
+func UsesBackend(names, name string) bool {
+	for _, n := range strings.Split(names, ",") {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ConfigMapBackend stores the mappings in the aws-auth ConfigMap. This is the
+// default backend.
+type ConfigMapBackend struct {
+	client.Client
+	// ConfigMapKey is the namespace and name of the ConfigMap. The aws-auth
+	// ConfigMap in kube-system is used if it is not set.
+	ConfigMapKey client.ObjectKey
+	// Reader reads the ConfigMap again after a conflicting write, bypassing
+	// the cache of the Client. The Client is used if it is not set.
+	Reader client.Reader
+	// History stores a snapshot of the ConfigMap before every write, if set.
+	History *History
+	// Protection refuses writes that lock out administrators or nodes, if
+	// set.
+	Protection *LockoutProtection
+}
+
+var _ Backend = &ConfigMapBackend{}
+
+// Name implements Backend.
+func (b *ConfigMapBackend) Name() string {
+	return BACKEND_CONFIGMAP
+}
+
+/*
+Load implements Backend.
+
+Other than GetAwsAuthMap it does not create the ConfigMap, a missing
+ConfigMap is reported as empty mappings.
+*/
+func (b *ConfigMapBackend) Load(ctx context.Context) (*Mappings, error) {
+	cm := &corev1.ConfigMap{}
+	err := b.Get(ctx, b.Key(), cm)
+	if err != nil {
+		if client.IgnoreNotFound(err) == nil {
+			return NewMappings(), nil
+		}
+		return nil, err
+	}
+	return b.Parse(cm)
+}
+
+// Key returns the namespace and name of the ConfigMap.
+func (b *ConfigMapBackend) Key() client.ObjectKey {
+	return (&AwsAuthMap{ConfigMapKey: b.ConfigMapKey}).key()
+}
+
+// Parse returns the mappings contained in the given ConfigMap.
+func (b *ConfigMapBackend) Parse(cm *corev1.ConfigMap) (*Mappings, error) {
+	awsauth := &AwsAuthMap{ConfigMap: cm}
+	if err := awsauth.parse(); err != nil {
+		return nil, err
+	}
+	return awsauth.Mappings(), nil
+}
+
+// Apply implements Backend.
+func (b *ConfigMapBackend) Apply(ctx context.Context, desired *DesiredState) (*Changes, error) {
+	awsauth := b.awsAuthMap()
+	if err := awsauth.Read(ctx); err != nil {
+		return nil, err
+	}
+	return awsauth.Apply(ctx, desired)
+}
+
+// awsAuthMap returns an AwsAuthMap for the ConfigMap that is not read yet.
+func (b *ConfigMapBackend) awsAuthMap() *AwsAuthMap {
+	return &AwsAuthMap{Client: b.Client, Reader: b.Reader, ConfigMapKey: b.ConfigMapKey, History: b.History, Protection: b.Protection}
+}
+
+/*
+InMemoryBackend keeps the mappings in memory only. It is meant for tests and
+for trying out snippets without touching the cluster's authentication.
+*/
+type InMemoryBackend struct {
+	mutex    sync.Mutex
+	mappings *Mappings
+}
+
+var _ Backend = &InMemoryBackend{}
+
+// NewInMemoryBackend returns an InMemoryBackend without any mappings.
+func NewInMemoryBackend() *InMemoryBackend {
+	return &InMemoryBackend{mappings: NewMappings()}
+}
+
+// Name implements Backend.
+func (b *InMemoryBackend) Name() string {
+	return BACKEND_MEMORY
+}
+
+// Load implements Backend. It returns a copy of the stored mappings.
+func (b *InMemoryBackend) Load(ctx context.Context) (*Mappings, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.mappings.Copy(), nil
+}
+
+// Apply implements Backend.
+func (b *InMemoryBackend) Apply(ctx context.Context, desired *DesiredState) (*Changes, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	before := b.mappings.Copy()
+	b.mappings.Merge(desired)
+	return diffMappings(before, b.mappings), nil
+}
+
+// Store replaces the stored mappings, e.g. to simulate entries that are not
+// managed by any snippet.
+func (b *InMemoryBackend) Store(mappings *Mappings) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.mappings = mappings.Copy()
+}
@@ -0,0 +1,216 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	crdv1beta1 "github.com/inovex/aws-auth-controller/pkg/api/v1beta1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("in-memory backend", func() {
+	const (
+		ROLE_ARN      = "arn:aws:iam::123456789012:role/memory"
+		USER_ARN      = "arn:aws:iam::123456789012:user/memory"
+		UNMANAGED_ARN = "arn:aws:iam::123456789012:role/unmanaged"
+	)
+
+	snippet := func(userName string) *crdv1beta1.AwsAuthMapSnippet {
+		return newSnippet("memory", userName, ROLE_ARN, USER_ARN)
+	}
+
+	It("should apply snippets and keep unmanaged entries", func() {
+		backend := NewInMemoryBackend()
+		unmanaged := NewMappings()
+		unmanaged.Roles[UNMANAGED_ARN] = newRole(UNMANAGED_ARN, "unmanaged", "system:nodes")
+		backend.Store(unmanaged)
+
+		changes, err := backend.Apply(context.Background(), ComputeDesiredState([]Snippet{snippet("first")}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes.Added).To(ConsistOf(ROLE_ARN, USER_ARN))
+		Expect(changes.Updated).To(BeEmpty())
+		Expect(changes.Removed).To(BeEmpty())
+
+		changes, err = backend.Apply(context.Background(), ComputeDesiredState([]Snippet{snippet("second")}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes.Updated).To(ConsistOf(ROLE_ARN, USER_ARN))
+
+		mappings, err := backend.Load(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mappings.Roles[ROLE_ARN].UserName).To(Equal("second"))
+		Expect(mappings.Users[USER_ARN].UserName).To(Equal("second"))
+		Expect(mappings.Roles).To(HaveKey(UNMANAGED_ARN))
+	})
+
+	It("should remove the entries of deleted snippets", func() {
+		backend := NewInMemoryBackend()
+		snip := snippet("first")
+		_, err := backend.Apply(context.Background(), ComputeDesiredState([]Snippet{snip}))
+		Expect(err).ToNot(HaveOccurred())
+
+		changes, err := backend.Apply(context.Background(), ComputeDesiredState([]Snippet{deleted(snip)}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes.Removed).To(ConsistOf(ROLE_ARN, USER_ARN))
+
+		mappings, err := backend.Load(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mappings.Roles).To(BeEmpty())
+		Expect(mappings.Users).To(BeEmpty())
+	})
+
+	It("should keep the entries of snippets that became invalid", func() {
+		backend := NewInMemoryBackend()
+		snip := snippet("first")
+		_, err := backend.Apply(context.Background(), ComputeDesiredState([]Snippet{snip}))
+		Expect(err).ToNot(HaveOccurred())
+		snip.Status.RoleArns = []string{ROLE_ARN}
+		snip.Status.UserArns = []string{USER_ARN}
+
+		// Accepted by the CRD, but not by the validation of the controller.
+		snip.Spec.MapUsers[0].Groups = nil
+		other := newSnippet("other", "other", USER_ARN)
+		other.CreationTimestamp = metav1.NewTime(time.Now().Add(time.Hour))
+		desired := ComputeDesiredState([]Snippet{snip, other})
+		Expect(desired.Conflicts["default/other"]).To(ConsistOf(Conflict{Arn: USER_ARN, Winner: "default/memory"}))
+		changes, err := backend.Apply(context.Background(), desired)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes.Empty()).To(BeTrue())
+
+		mappings, err := backend.Load(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mappings.Roles[ROLE_ARN].UserName).To(Equal("first"))
+		Expect(mappings.Users[USER_ARN].UserName).To(Equal("first"))
+
+		changes, err = backend.Apply(context.Background(), ComputeDesiredState([]Snippet{deleted(snip)}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes.Removed).To(ConsistOf(ROLE_ARN, USER_ARN))
+	})
+})
+
+var _ = Describe("ConfigMap backend ownership index", func() {
+	const (
+		ROLE_ARN    = "arn:aws:iam::123456789012:role/owned"
+		USER_ARN    = "arn:aws:iam::123456789012:user/owned"
+		FOREIGN_ARN = "arn:aws:iam::123456789012:role/foreign"
+		NODE_ARN    = "arn:aws:iam::123456789012:role/node"
+	)
+
+	var (
+		c       client.Client
+		backend *ConfigMapBackend
+	)
+
+	BeforeEach(func() {
+		current := NewMappings()
+		current.Roles[NODE_ARN] = newRole(NODE_ARN, "system:node:{{EC2PrivateDNSName}}", "system:nodes")
+		current.Roles[FOREIGN_ARN] = newRole(FOREIGN_ARN, "foreign", "foreign")
+		current.Owners[FOREIGN_ARN] = "other/foreign"
+		c = newFakeClient(newConfigMap(current))
+		backend = &ConfigMapBackend{Client: c}
+	})
+
+	snippet := func(arns ...string) *crdv1beta1.AwsAuthMapSnippet {
+		return newSnippet("owned", "owned", arns...)
+	}
+
+	It("should record the owner of every managed entry", func() {
+		_, err := backend.Apply(context.Background(), ComputeDesiredState([]Snippet{snippet(ROLE_ARN, USER_ARN)}))
+		Expect(err).ToNot(HaveOccurred())
+
+		mappings, err := backend.Load(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mappings.Owners).To(Equal(map[string]string{
+			ROLE_ARN:    "default/owned",
+			USER_ARN:    "default/owned",
+			FOREIGN_ARN: "other/foreign",
+		}))
+		Expect(mappings.Roles).To(HaveKey(NODE_ARN))
+	})
+
+	It("should remove entries by the index if the status is lost", func() {
+		_, err := backend.Apply(context.Background(), ComputeDesiredState([]Snippet{snippet(ROLE_ARN, USER_ARN)}))
+		Expect(err).ToNot(HaveOccurred())
+
+		// The snippet has no status, only the index knows about USER_ARN.
+		changes, err := backend.Apply(context.Background(), ComputeDesiredState([]Snippet{snippet(ROLE_ARN)}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes.Removed).To(ConsistOf(USER_ARN))
+
+		mappings, err := backend.Load(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mappings.Users).To(BeEmpty())
+		Expect(mappings.Owners).ToNot(HaveKey(USER_ARN))
+		Expect(mappings.Roles).To(HaveKey(NODE_ARN))
+		Expect(mappings.Roles).To(HaveKey(FOREIGN_ARN))
+	})
+
+	It("should restore the original entry of a deleted snippet", func() {
+		snip := snippet(NODE_ARN)
+		_, err := backend.Apply(context.Background(), ComputeDesiredState([]Snippet{snip}))
+		Expect(err).ToNot(HaveOccurred())
+
+		mappings, err := backend.Load(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mappings.Roles[NODE_ARN].UserName).To(Equal("owned"))
+		Expect(mappings.Originals).To(HaveKey(NODE_ARN))
+		Expect(mappings.Originals[NODE_ARN].Role.Groups).To(ConsistOf("system:nodes"))
+
+		// Applying again must not replace the remembered entry.
+		snip.Status.RoleArns = []string{NODE_ARN}
+		_, err = backend.Apply(context.Background(), ComputeDesiredState([]Snippet{snip}))
+		Expect(err).ToNot(HaveOccurred())
+
+		changes, err := backend.Apply(context.Background(), ComputeDesiredState([]Snippet{deleted(snip)}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes.Updated).To(ConsistOf(NODE_ARN))
+
+		mappings, err = backend.Load(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mappings.Roles[NODE_ARN].UserName).To(Equal("system:node:{{EC2PrivateDNSName}}"))
+		Expect(mappings.Owners).ToNot(HaveKey(NODE_ARN))
+		Expect(mappings.Originals).To(BeEmpty())
+
+		cm := &corev1.ConfigMap{}
+		Expect(c.Get(context.Background(), backend.Key(), cm)).To(Succeed())
+		Expect(cm.Annotations).ToNot(HaveKey(ORIGINALS_ANNOTATION))
+	})
+
+	It("should read the ConfigMap through the reader after a conflict", func() {
+		cached := &corev1.ConfigMap{}
+		Expect(c.Get(context.Background(), backend.Key(), cached)).To(Succeed())
+		// Another writer adds a mapping, but the cache keeps the old version.
+		current, err := backend.Load(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		current.Users[USER_ARN] = newUser(USER_ARN, "concurrent", "concurrent")
+		Expect(c.Update(context.Background(), newConfigMap(current))).To(Succeed())
+
+		backend.Client = &staleClient{Client: c, cached: cached}
+		backend.Reader = c
+		_, err = backend.Apply(context.Background(), ComputeDesiredState([]Snippet{snippet(ROLE_ARN)}))
+		Expect(err).ToNot(HaveOccurred())
+
+		mappings, err := (&ConfigMapBackend{Client: c}).Load(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mappings.Roles).To(HaveKey(ROLE_ARN))
+		Expect(mappings.Users[USER_ARN].UserName).To(Equal("concurrent"))
+	})
+})
+
+// staleClient returns an outdated copy of the ConfigMap, like a cache that
+// has not seen the latest update yet.
+type staleClient struct {
+	client.Client
+	cached *corev1.ConfigMap
+}
+
+func (s *staleClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	if cm, ok := obj.(*corev1.ConfigMap); ok && key == client.ObjectKeyFromObject(s.cached) {
+		s.cached.DeepCopyInto(cm)
+		return nil
+	}
+	return s.Client.Get(ctx, key, obj, opts...)
+}
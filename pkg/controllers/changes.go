@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+// Changes lists the ARNs and account IDs that were added, updated or removed
+// by a write.
+type Changes struct {
+	Added   []string
+	Updated []string
+	Removed []string
+	// Adopted lists the unmanaged entries that became managed without being
+	// modified, see ADOPT_ANNOTATION. They are not part of the other lists.
+	Adopted []string
+	// Blocked holds the reasons why entries were kept as they were by the
+	// lock-out protection, by the key of the snippet that owns them.
+	Blocked map[string][]string
+	// Skipped holds the reasons why the backend did not write entries of the
+	// desired state, by the key of the snippet that declares them.
+	Skipped map[string][]string
+
+	// Mappings are the mappings after the write, including the ownership
+	// index and the original entries.
+	Mappings *Mappings
+}
+
+// Empty returns true if no mapping was changed. Adopting entries only changes
+// the ownership index.
+func (c *Changes) Empty() bool {
+	return len(c.Added) == 0 && len(c.Updated) == 0 && len(c.Removed) == 0
+}
+
+// diffMappings compares two sets of mappings. The Mappings of the result are
+// after.
+func diffMappings(before, after *Mappings) *Changes {
+	changes := &Changes{Mappings: after}
+
+	for ra, mr := range after.Roles {
+		old, found := before.Roles[ra]
+		if !found {
+			changes.Added = append(changes.Added, ra)
+		} else if !equality.Semantic.DeepEqual(old, mr) {
+			changes.Updated = append(changes.Updated, ra)
+		}
+	}
+	for ra := range before.Roles {
+		if _, found := after.Roles[ra]; !found {
+			changes.Removed = append(changes.Removed, ra)
+		}
+	}
+
+	for ua, mu := range after.Users {
+		old, found := before.Users[ua]
+		if !found {
+			changes.Added = append(changes.Added, ua)
+		} else if !equality.Semantic.DeepEqual(old, mu) {
+			changes.Updated = append(changes.Updated, ua)
+		}
+	}
+	for ua := range before.Users {
+		if _, found := after.Users[ua]; !found {
+			changes.Removed = append(changes.Removed, ua)
+		}
+	}
+
+	for aid := range after.Accounts {
+		if !before.Accounts[aid] {
+			changes.Added = append(changes.Added, aid)
+		}
+	}
+	for aid := range before.Accounts {
+		if !after.Accounts[aid] {
+			changes.Removed = append(changes.Removed, aid)
+		}
+	}
+
+	for arn := range after.Owners {
+		_, owned := before.Owners[arn]
+		_, remembered := after.Originals[arn]
+		if !owned && !remembered && !containsString(changes.Added, arn) && !containsString(changes.Updated, arn) {
+			changes.Adopted = append(changes.Adopted, arn)
+		}
+	}
+
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Updated)
+	sort.Strings(changes.Removed)
+	sort.Strings(changes.Adopted)
+	return changes
+}
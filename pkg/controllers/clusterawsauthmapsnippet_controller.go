@@ -0,0 +1,89 @@
+/*
+Copyright 2023 inovex GmbH
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	crdv1beta1 "github.com/inovex/aws-auth-controller/pkg/api/v1beta1"
+	"github.com/inovex/aws-auth-controller/pkg/predicates"
+)
+
+/*
+ClusterAwsAuthMapSnippetReconciler reconciles a ClusterAwsAuthMapSnippet
+object.
+
+It shares the client, options and ConfigMap logic with the
+AwsAuthMapSnippetReconciler. Cluster-scoped snippets are never subject to the
+namespace filter.
+*/
+type ClusterAwsAuthMapSnippetReconciler struct {
+	*AwsAuthMapSnippetReconciler
+}
+
+//+kubebuilder:rbac:groups=crd.awsauth.io,resources=clusterawsauthmapsnippets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=crd.awsauth.io,resources=clusterawsauthmapsnippets/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=crd.awsauth.io,resources=clusterawsauthmapsnippets/finalizers,verbs=update
+
+// Reconcile is part of the main kubernetes reconciliation loop which aims to
+// move the current state of the cluster closer to the desired state.
+func (r *ClusterAwsAuthMapSnippetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	logger.Info("Reconcile Request received", "objectName", req.NamespacedName)
+
+	snippet := &crdv1beta1.ClusterAwsAuthMapSnippet{}
+	err := r.Get(ctx, client.ObjectKey{Name: req.NamespacedName.Name}, snippet)
+
+	if err != nil {
+		if apierrs.IsNotFound(err) {
+			logger.Info("Resource already deleted, Reconciliation not needed.")
+		}
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	return r.reconcileSnippet(ctx, snippet)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ClusterAwsAuthMapSnippetReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	classFilter := predicates.ControllerClassFilter(r.Options.ControllerClass)
+	b := ctrl.NewControllerManagedBy(mgr).
+		// Ignore updates of the status, the controller writes it itself.
+		For(&crdv1beta1.ClusterAwsAuthMapSnippet{}, builder.WithPredicates(classFilter, specChangedPredicate)).
+		// Snippets that declare the same ARNs need to re-evaluate their
+		// conflicts, e.g. to take over an ARN from a deleted snippet.
+		Watches(
+			&source.Kind{Type: &crdv1beta1.AwsAuthMapSnippet{}},
+			handler.EnqueueRequestsFromMapFunc(r.findConflictingSnippets(true)),
+			builder.WithPredicates(predicates.NamespaceFilter(r.Options.Namespaces), classFilter, predicate.GenerationChangedPredicate{}),
+		).
+		Watches(
+			&source.Kind{Type: &crdv1beta1.ClusterAwsAuthMapSnippet{}},
+			handler.EnqueueRequestsFromMapFunc(r.findConflictingSnippets(true)),
+			builder.WithPredicates(classFilter, predicate.GenerationChangedPredicate{}),
+		)
+	r.watchConfigMap(b, true)
+	return b.Complete(r)
+}
@@ -0,0 +1,107 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// BackendResult is the outcome of applying the desired state to one backend.
+type BackendResult struct {
+	Backend string
+	Changes *Changes
+	Err     error
+}
+
+/*
+CompositeBackend writes the same desired state to several backends, e.g. to
+migrate from the aws-auth ConfigMap to access entries without an outage.
+
+The first backend is the primary one. Its mappings are returned by Load and
+the other backends are compared against it to detect divergence.
+*/
+type CompositeBackend struct {
+	Backends []Backend
+}
+
+var _ Backend = &CompositeBackend{}
+
+// Name implements Backend. It joins the names of all backends with a comma,
+// as given to the --backend flag.
+func (b *CompositeBackend) Name() string {
+	names := []string{}
+	for _, backend := range b.Backends {
+		names = append(names, backend.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+// Load implements Backend. It returns the mappings of the primary backend.
+func (b *CompositeBackend) Load(ctx context.Context) (*Mappings, error) {
+	return b.Backends[0].Load(ctx)
+}
+
+// Apply implements Backend. It returns the changes of the primary backend and
+// the errors of all backends.
+func (b *CompositeBackend) Apply(ctx context.Context, desired *DesiredState) (*Changes, error) {
+	results := b.ApplyEach(ctx, desired)
+	errs := []error{}
+	for _, result := range results {
+		if result.Err != nil {
+			errs = append(errs, result.Err)
+		}
+	}
+	return results[0].Changes, utilerrors.NewAggregate(errs)
+}
+
+// ApplyEach applies the desired state to every backend, even if one of them
+// fails, and returns the individual results.
+func (b *CompositeBackend) ApplyEach(ctx context.Context, desired *DesiredState) []BackendResult {
+	results := []BackendResult{}
+	for _, backend := range b.Backends {
+		changes, err := backend.Apply(ctx, desired)
+		results = append(results, BackendResult{Backend: backend.Name(), Changes: changes, Err: err})
+	}
+	return results
+}
+
+/*
+Divergence compares the role and user mappings of the desired state that the
+backends hold after applying it with the ones of the primary backend. It
+returns the ARNs that differ, by the name of the backend they differ in.
+Backends whose result has no mappings, e.g. as they failed, are left out.
+
+Account mappings are not compared as not every backend supports them.
+*/
+func Divergence(results []BackendResult, desired *DesiredState) map[string][]string {
+	divergence := map[string][]string{}
+	if len(results) == 0 || results[0].Changes == nil || results[0].Changes.Mappings == nil {
+		return divergence
+	}
+	primary := results[0].Changes.Mappings
+	for _, result := range results[1:] {
+		if result.Changes == nil || result.Changes.Mappings == nil {
+			continue
+		}
+		other := result.Changes.Mappings
+		arns := []string{}
+		for ra := range desired.Roles {
+			pmr, inPrimary := primary.Roles[ra]
+			omr, inOther := other.Roles[ra]
+			if inPrimary != inOther || !equality.Semantic.DeepEqual(pmr, omr) {
+				arns = append(arns, ra)
+			}
+		}
+		for ua := range desired.Users {
+			pmu, inPrimary := primary.Users[ua]
+			omu, inOther := other.Users[ua]
+			if inPrimary != inOther || !equality.Semantic.DeepEqual(pmu, omu) {
+				arns = append(arns, ua)
+			}
+		}
+		divergence[result.Backend] = arns
+	}
+	return divergence
+}
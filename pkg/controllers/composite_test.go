@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+
+	crdv1beta1 "github.com/inovex/aws-auth-controller/pkg/api/v1beta1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// failingBackend fails every Apply.
+type failingBackend struct {
+	*InMemoryBackend
+}
+
+func (b *failingBackend) Name() string {
+	return "failing"
+}
+
+func (b *failingBackend) Apply(ctx context.Context, desired *DesiredState) (*Changes, error) {
+	return nil, errors.New("backend unavailable")
+}
+
+var _ = Describe("composite backend", func() {
+	const ROLE_ARN = "arn:aws:iam::123456789012:role/composite"
+
+	desired := func() *DesiredState {
+		return ComputeDesiredState([]Snippet{newSnippet("composite", "composite", ROLE_ARN)})
+	}
+
+	It("should write to all backends and detect divergence", func() {
+		fake := NewFakeEKSClient()
+		primary, secondary := NewInMemoryBackend(), &AccessEntriesBackend{Client: fake, ClusterName: "test"}
+		composite := &CompositeBackend{Backends: []Backend{primary, secondary}}
+		Expect(composite.Name()).To(Equal("memory,accessentries"))
+
+		changes, err := composite.Apply(context.Background(), desired())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes.Added).To(ConsistOf(ROLE_ARN))
+
+		results := composite.ApplyEach(context.Background(), desired())
+		Expect(Divergence(results, desired())).To(HaveKeyWithValue("accessentries", BeEmpty()))
+
+		// The access entry can not be updated
+		fake.Failing[ROLE_ARN] = errors.New("throttled")
+		updated := desired()
+		updated.Roles[ROLE_ARN] = newRole(ROLE_ARN, "updated", "foobar-group")
+		results = composite.ApplyEach(context.Background(), updated)
+		Expect(results[1].Err).To(HaveOccurred())
+		Expect(Divergence(results, updated)).To(HaveKeyWithValue("accessentries", ConsistOf(ROLE_ARN)))
+	})
+
+	It("should not be ready while the backends diverge", func() {
+		snip := newSnippet("composite", "composite", ROLE_ARN)
+		setSyncedCondition(snip, nil)
+		setDivergedCondition(snip, map[string][]string{"accessentries": {ROLE_ARN}}, desired())
+		setReadyCondition(snip)
+		ready := meta.FindStatusCondition(snip.Status.Conditions, crdv1beta1.ConditionReady)
+		Expect(ready.Status).To(Equal(metav1.ConditionFalse))
+		Expect(ready.Reason).To(Equal(crdv1beta1.ReasonBackendsDiverged))
+	})
+
+	It("should apply to the remaining backends if one fails", func() {
+		primary, secondary := NewInMemoryBackend(), &failingBackend{NewInMemoryBackend()}
+		composite := &CompositeBackend{Backends: []Backend{primary, secondary}}
+
+		results := composite.ApplyEach(context.Background(), desired())
+		Expect(results).To(HaveLen(2))
+		Expect(results[0].Err).ToNot(HaveOccurred())
+		Expect(results[1].Err).To(HaveOccurred())
+
+		mappings, err := primary.Load(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mappings.Roles).To(HaveKey(ROLE_ARN))
+
+		_, err = composite.Apply(context.Background(), desired())
+		Expect(err).To(MatchError(ContainSubstring("backend unavailable")))
+	})
+
+	It("should be created from a comma-separated list", func() {
+		backend, err := NewBackend("configmap,memory", BackendOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(backend).To(BeAssignableToTypeOf(&CompositeBackend{}))
+		Expect(backend.Name()).To(Equal("configmap,memory"))
+	})
+
+	It("should be created with access entries for a migration", func() {
+		const names = "configmap,accessentries"
+		Expect(UsesBackend(names, BACKEND_ACCESS_ENTRIES)).To(BeTrue())
+		Expect(UsesBackend(names, BACKEND_FILE)).To(BeFalse())
+
+		_, err := NewBackend(names, BackendOptions{})
+		Expect(err).To(MatchError(ContainSubstring("needs an EKS client")))
+		backend, err := NewBackend(names, BackendOptions{EKSClient: NewFakeEKSClient(), ClusterName: "cluster"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(backend.(*CompositeBackend).Backends).To(HaveLen(2))
+		Expect(backend.(*CompositeBackend).Backends[1]).To(BeAssignableToTypeOf(&AccessEntriesBackend{}))
+	})
+})
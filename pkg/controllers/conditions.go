@@ -0,0 +1,342 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	crdv1beta1 "github.com/inovex/aws-auth-controller/pkg/api/v1beta1"
+)
+
+/*
+specChangedPredicate lets events pass that change the spec, the metadata
+annotations or the deletion timestamp of a snippet, but not the status.
+
+Otherwise every status update, e.g. of LastSyncTime, would trigger another
+reconciliation.
+*/
+var specChangedPredicate = predicate.Or(
+	predicate.GenerationChangedPredicate{},
+	predicate.AnnotationChangedPredicate{},
+)
+
+/*
+setSyncedCondition reports the outcome of the last write of the mappings in
+the Synced condition, LastSyncTime and LastError.
+*/
+func setSyncedCondition(snippet Snippet, err error) {
+	status := snippet.GetStatus()
+	if err != nil {
+		reason := crdv1beta1.ReasonSyncFailed
+		var blocked *BlockedError
+		if errors.As(err, &blocked) {
+			reason = crdv1beta1.ReasonLockoutProtection
+		}
+		status.LastError = err.Error()
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               crdv1beta1.ConditionSynced,
+			Status:             metav1.ConditionFalse,
+			Reason:             reason,
+			Message:            err.Error(),
+			ObservedGeneration: snippet.GetGeneration(),
+		})
+		return
+	}
+
+	status.LastError = ""
+	status.LastSyncTime = &metav1.Time{Time: time.Now()}
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               crdv1beta1.ConditionSynced,
+		Status:             metav1.ConditionTrue,
+		Reason:             crdv1beta1.ReasonSyncSucceeded,
+		Message:            "Mappings were written",
+		ObservedGeneration: snippet.GetGeneration(),
+	})
+}
+
+// setDryRunCondition reports in the Synced condition that the mappings were
+// not written because of the dry-run mode.
+func setDryRunCondition(snippet Snippet) {
+	status := snippet.GetStatus()
+	status.LastError = ""
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               crdv1beta1.ConditionSynced,
+		Status:             metav1.ConditionFalse,
+		Reason:             crdv1beta1.ReasonDryRun,
+		Message:            "Dry run, the mappings were not written, see status.dryRunDiff",
+		ObservedGeneration: snippet.GetGeneration(),
+	})
+}
+
+// setPausedCondition reports in the Synced condition that the mappings were
+// not written because an AwsAuthMapRestore pauses the reconciliation.
+func setPausedCondition(snippet Snippet, restore string) {
+	status := snippet.GetStatus()
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               crdv1beta1.ConditionSynced,
+		Status:             metav1.ConditionFalse,
+		Reason:             crdv1beta1.ReasonPausedByRestore,
+		Message:            fmt.Sprintf("Reconciliation is paused by AwsAuthMapRestore %s", restore),
+		ObservedGeneration: snippet.GetGeneration(),
+	})
+}
+
+/*
+setBlockedCondition reports in the Blocked condition whether the lock-out
+protection refused one of the results as a whole or kept entries of the
+snippet. It returns the reasons, which are empty if the snippet is not
+blocked.
+*/
+func setBlockedCondition(snippet Snippet, results []BackendResult) []string {
+	key := snippetKey(snippet)
+	reasons := []string{}
+	for _, result := range results {
+		var blocked *BlockedError
+		if errors.As(result.Err, &blocked) {
+			reasons = append(reasons, fmt.Sprintf("backend %s: %s", result.Backend, strings.Join(blocked.Reasons, "; ")))
+		} else if result.Changes != nil && len(result.Changes.Blocked[key]) > 0 {
+			reasons = append(reasons, fmt.Sprintf("backend %s: %s", result.Backend, strings.Join(result.Changes.Blocked[key], "; ")))
+		}
+	}
+
+	status := snippet.GetStatus()
+	if len(reasons) > 0 {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               crdv1beta1.ConditionBlocked,
+			Status:             metav1.ConditionTrue,
+			Reason:             crdv1beta1.ReasonLockoutProtection,
+			Message:            "Refused by lock-out protection, " + strings.Join(reasons, "; "),
+			ObservedGeneration: snippet.GetGeneration(),
+		})
+		return reasons
+	}
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               crdv1beta1.ConditionBlocked,
+		Status:             metav1.ConditionFalse,
+		Reason:             crdv1beta1.ReasonNotBlocked,
+		Message:            "The mappings keep administrators and nodes able to access the cluster",
+		ObservedGeneration: snippet.GetGeneration(),
+	})
+	return nil
+}
+
+/*
+setConflictCondition reports the ARNs that the snippet lost to other snippets
+in its Conflict condition.
+*/
+func setConflictCondition(snippet Snippet, conflicts []Conflict) {
+	status := snippet.GetStatus()
+	if len(conflicts) == 0 {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               crdv1beta1.ConditionConflict,
+			Status:             metav1.ConditionFalse,
+			Reason:             crdv1beta1.ReasonNoConflict,
+			Message:            "All ARNs are owned by this snippet",
+			ObservedGeneration: snippet.GetGeneration(),
+		})
+		return
+	}
+
+	messages := []string{}
+	for _, c := range conflicts {
+		messages = append(messages, fmt.Sprintf("%s is claimed by snippet %s", c.Arn, c.Winner))
+	}
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               crdv1beta1.ConditionConflict,
+		Status:             metav1.ConditionTrue,
+		Reason:             crdv1beta1.ReasonArnClaimed,
+		Message:            strings.Join(messages, "; "),
+		ObservedGeneration: snippet.GetGeneration(),
+	})
+}
+
+/*
+setRejectedCondition reports the protected ARNs and account IDs declared by
+the snippet, and the entries of the snippet that were skipped by one of the
+backends, in its Rejected condition. The results are nil before the desired
+state is applied.
+*/
+func setRejectedCondition(snippet Snippet, protected []string, results []BackendResult) {
+	key := snippetKey(snippet)
+	status := snippet.GetStatus()
+	reason := crdv1beta1.ReasonUnsupportedEntry
+	messages := []string{}
+	if len(protected) > 0 {
+		reason = crdv1beta1.ReasonProtectedArn
+		messages = append(messages, "Protected entries are not applied: "+strings.Join(protected, ", "))
+	}
+	for _, result := range results {
+		if result.Changes != nil && len(result.Changes.Skipped[key]) > 0 {
+			messages = append(messages, fmt.Sprintf("Backend %s skipped entries: %s",
+				result.Backend, strings.Join(result.Changes.Skipped[key], "; ")))
+		}
+	}
+
+	if len(messages) == 0 {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               crdv1beta1.ConditionRejected,
+			Status:             metav1.ConditionFalse,
+			Reason:             crdv1beta1.ReasonNoProtectedArn,
+			Message:            "No ARN of the snippet is protected or unsupported",
+			ObservedGeneration: snippet.GetGeneration(),
+		})
+		return
+	}
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               crdv1beta1.ConditionRejected,
+		Status:             metav1.ConditionTrue,
+		Reason:             reason,
+		Message:            strings.Join(messages, "; "),
+		ObservedGeneration: snippet.GetGeneration(),
+	})
+}
+
+// setInvalidCondition reports validation errors of the spec in the Invalid
+// condition.
+func setInvalidCondition(snippet Snippet) {
+	status := snippet.GetStatus()
+	errs := snippet.GetSpec().Validate(field.NewPath("spec"))
+	if len(errs) == 0 {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               crdv1beta1.ConditionInvalid,
+			Status:             metav1.ConditionFalse,
+			Reason:             crdv1beta1.ReasonValid,
+			Message:            "The spec is valid",
+			ObservedGeneration: snippet.GetGeneration(),
+		})
+		return
+	}
+
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               crdv1beta1.ConditionInvalid,
+		Status:             metav1.ConditionTrue,
+		Reason:             crdv1beta1.ReasonValidationFailed,
+		Message:            errs.ToAggregate().Error(),
+		ObservedGeneration: snippet.GetGeneration(),
+	})
+}
+
+/*
+setReadyCondition summarizes the other conditions. The snippet is ready if it
+is valid, synced, none of its ARNs is claimed by another snippet or rejected,
+and its mappings are the same in all backends.
+*/
+func setReadyCondition(snippet Snippet) {
+	status := snippet.GetStatus()
+	ready := metav1.Condition{
+		Type:               crdv1beta1.ConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             crdv1beta1.ReasonReconciled,
+		Message:            "All mappings are applied",
+		ObservedGeneration: snippet.GetGeneration(),
+	}
+
+	invalid := meta.FindStatusCondition(status.Conditions, crdv1beta1.ConditionInvalid)
+	synced := meta.FindStatusCondition(status.Conditions, crdv1beta1.ConditionSynced)
+	conflict := meta.FindStatusCondition(status.Conditions, crdv1beta1.ConditionConflict)
+	rejected := meta.FindStatusCondition(status.Conditions, crdv1beta1.ConditionRejected)
+	diverged := meta.FindStatusCondition(status.Conditions, crdv1beta1.ConditionDiverged)
+	switch {
+	case invalid != nil && invalid.Status == metav1.ConditionTrue:
+		ready.Status, ready.Reason, ready.Message = metav1.ConditionFalse, invalid.Reason, invalid.Message
+	case synced == nil:
+		ready.Status, ready.Reason, ready.Message = metav1.ConditionFalse, crdv1beta1.ReasonSyncFailed, "Mappings were not written yet"
+	case synced.Status != metav1.ConditionTrue:
+		ready.Status, ready.Reason, ready.Message = metav1.ConditionFalse, synced.Reason, synced.Message
+	case conflict != nil && conflict.Status == metav1.ConditionTrue:
+		ready.Status, ready.Reason, ready.Message = metav1.ConditionFalse, conflict.Reason, conflict.Message
+	case rejected != nil && rejected.Status == metav1.ConditionTrue:
+		ready.Status, ready.Reason, ready.Message = metav1.ConditionFalse, rejected.Reason, rejected.Message
+	case diverged != nil && diverged.Status == metav1.ConditionTrue:
+		ready.Status, ready.Reason, ready.Message = metav1.ConditionFalse, diverged.Reason, diverged.Message
+	}
+	meta.SetStatusCondition(&status.Conditions, ready)
+}
+
+// setBackendStatus stores the outcome of the last sync of every backend.
+func setBackendStatus(snippet Snippet, results []BackendResult) {
+	status := snippet.GetStatus()
+	status.Backends = []crdv1beta1.BackendStatus{}
+	for _, result := range results {
+		backendStatus := crdv1beta1.BackendStatus{Name: result.Backend, Synced: result.Err == nil}
+		if result.Err != nil {
+			backendStatus.LastError = result.Err.Error()
+		}
+		status.Backends = append(status.Backends, backendStatus)
+	}
+}
+
+/*
+setPendingRestores lists the entries of the snippet that replaced an existing
+entry in one of the backends in the status of the snippet.
+*/
+func setPendingRestores(snippet Snippet, results []BackendResult) {
+	key := snippetKey(snippet)
+	var pending []string
+	for _, result := range results {
+		if result.Changes == nil || result.Changes.Mappings == nil {
+			continue
+		}
+		mappings := result.Changes.Mappings
+		for arn := range mappings.Originals {
+			if mappings.Owners[arn] == key && !containsString(pending, arn) {
+				pending = append(pending, arn)
+			}
+		}
+	}
+	sort.Strings(pending)
+	snippet.GetStatus().PendingRestores = pending
+}
+
+/*
+setDivergedCondition reports the ARNs of the snippet whose mappings differ
+between the backends in its Diverged condition.
+*/
+func setDivergedCondition(snippet Snippet, divergence map[string][]string, desired *DesiredState) {
+	key := snippetKey(snippet)
+	backends := []string{}
+	for backend := range divergence {
+		backends = append(backends, backend)
+	}
+	sort.Strings(backends)
+
+	messages := []string{}
+	for _, backend := range backends {
+		arns := []string{}
+		for _, arn := range divergence[backend] {
+			if desired.Owners[arn] == key {
+				arns = append(arns, arn)
+			}
+		}
+		if len(arns) > 0 {
+			sort.Strings(arns)
+			messages = append(messages, fmt.Sprintf("%s differs in backend %s", strings.Join(arns, ", "), backend))
+		}
+	}
+
+	status := snippet.GetStatus()
+	if len(messages) == 0 {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               crdv1beta1.ConditionDiverged,
+			Status:             metav1.ConditionFalse,
+			Reason:             crdv1beta1.ReasonBackendsConsistent,
+			Message:            "All backends contain the same mappings",
+			ObservedGeneration: snippet.GetGeneration(),
+		})
+		return
+	}
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               crdv1beta1.ConditionDiverged,
+		Status:             metav1.ConditionTrue,
+		Reason:             crdv1beta1.ReasonBackendsDiverged,
+		Message:            strings.Join(messages, "; "),
+		ObservedGeneration: snippet.GetGeneration(),
+	})
+}
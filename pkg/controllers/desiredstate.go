@@ -0,0 +1,231 @@
+package controllers
+
+import (
+	"sort"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+/*
+DesiredState holds the mappings declared by all snippets together with the
+ARNs and account IDs that are managed by the controller.
+
+ARNs and account IDs that are listed as managed but are not part of Roles,
+Users or Accounts anymore are removed from the ConfigMap. All other entries
+of the ConfigMap were never managed by a snippet and are left untouched.
+*/
+type DesiredState struct {
+	Roles    MapRolesByArn
+	Users    MapUsersByArn
+	Accounts MapAccountsByID
+
+	ManagedRoleArns map[string]bool
+	ManagedUserArns map[string]bool
+	ManagedAccounts map[string]bool
+
+	// Owners maps every desired ARN and account ID to the key of the snippet
+	// that won it.
+	Owners map[string]string
+	// Conflicts lists the ARNs each snippet lost to another snippet, by
+	// snippet key.
+	Conflicts map[string][]Conflict
+	// Snippets holds the keys of all snippets the state was computed from.
+	// Entries that the ownership index assigns to one of them are managed.
+	Snippets map[string]bool
+	// Applied holds the ARNs and account IDs listed in the status of a
+	// snippet, i.e. the ones that were written by the controller before.
+	Applied map[string]bool
+	// Protected entries are neither added, modified nor removed, see
+	// Protect.
+	Protected ProtectedArns
+	// Adopting holds the keys of the snippets with the ADOPT_ANNOTATION.
+	Adopting map[string]bool
+	// Kept maps the ARNs of invalid snippets to their keys. Their entries
+	// are kept as they are until the snippet is fixed or deleted.
+	Kept map[string]string
+}
+
+// Conflict describes an ARN that is declared by more than one snippet.
+type Conflict struct {
+	Arn    string
+	Winner string
+}
+
+/*
+ComputeDesiredState merges the specs of all given snippets into one
+DesiredState.
+
+If an ARN is declared by more than one snippet, cluster-scoped snippets win
+over namespaced ones, then the oldest snippet wins. Ties are broken by
+namespace and name so that the result is deterministic.
+
+Snippets that are being deleted do not contribute any mappings, but the ARNs
+and account IDs from their spec and status are still considered managed so
+that they get removed.
+
+Snippets that are invalid neither contribute nor remove mappings. Since e.g.
+a stricter validation after an upgrade must not remove live mappings, the
+entries they wrote before are kept as they are, and other snippets cannot
+take over their ARNs.
+*/
+func ComputeDesiredState(snippets []Snippet) *DesiredState {
+	desired := &DesiredState{
+		Roles:           MapRolesByArn{},
+		Users:           MapUsersByArn{},
+		Accounts:        MapAccountsByID{},
+		ManagedRoleArns: map[string]bool{},
+		ManagedUserArns: map[string]bool{},
+		ManagedAccounts: map[string]bool{},
+		Owners:          map[string]string{},
+		Conflicts:       map[string][]Conflict{},
+		Snippets:        map[string]bool{},
+		Applied:         map[string]bool{},
+		Adopting:        map[string]bool{},
+		Kept:            map[string]string{},
+	}
+
+	sorted := make([]Snippet, len(snippets))
+	copy(sorted, snippets)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return snippetPrecedes(sorted[i], sorted[j])
+	})
+
+	for _, snippet := range sorted {
+		key := snippetKey(snippet)
+		spec, status := snippet.GetSpec(), snippet.GetStatus()
+		deleted := !snippet.GetDeletionTimestamp().IsZero()
+		if !deleted && len(spec.Validate(field.NewPath("spec"))) > 0 {
+			desired.keep(snippet)
+			continue
+		}
+		desired.Snippets[key] = true
+		if snippet.GetAnnotations()[ADOPT_ANNOTATION] == "true" {
+			desired.Adopting[key] = true
+		}
+		for _, ra := range status.RoleArns {
+			desired.ManagedRoleArns[ra] = true
+			desired.Applied[ra] = true
+		}
+		for _, ua := range status.UserArns {
+			desired.ManagedUserArns[ua] = true
+			desired.Applied[ua] = true
+		}
+		for _, aid := range status.Accounts {
+			desired.ManagedAccounts[aid] = true
+			desired.Applied[aid] = true
+		}
+
+		for _, mr := range spec.MapRoles {
+			desired.ManagedRoleArns[mr.RoleArn] = true
+			if deleted {
+				continue
+			}
+			if desired.claim(mr.RoleArn, key) {
+				desired.Roles[mr.RoleArn] = mr
+			}
+		}
+		for _, mu := range spec.MapUsers {
+			desired.ManagedUserArns[mu.UserArn] = true
+			if deleted {
+				continue
+			}
+			if desired.claim(mu.UserArn, key) {
+				desired.Users[mu.UserArn] = mu
+			}
+		}
+		for _, ma := range spec.MapAccounts {
+			aid := string(ma)
+			desired.ManagedAccounts[aid] = true
+			if deleted {
+				continue
+			}
+			// Account mappings carry no data that could differ between
+			// snippets, so they never conflict.
+			if _, found := desired.Owners[aid]; !found {
+				desired.Owners[aid] = key
+			}
+			desired.Accounts[aid] = true
+		}
+	}
+	for arn := range desired.Kept {
+		delete(desired.ManagedRoleArns, arn)
+		delete(desired.ManagedUserArns, arn)
+	}
+	return desired
+}
+
+/*
+Protect removes the protected ARNs and account IDs from the desired state, so
+that their entries are kept as they are, no matter which snippet declares
+them.
+*/
+func (d *DesiredState) Protect(protected ProtectedArns) {
+	d.Protected = protected
+	if len(protected) == 0 {
+		return
+	}
+	for _, arns := range []map[string]bool{d.ManagedRoleArns, d.ManagedUserArns, d.ManagedAccounts} {
+		for arn := range arns {
+			if protected.Matches(arn) {
+				delete(arns, arn)
+			}
+		}
+	}
+	for ra := range d.Roles {
+		if protected.Matches(ra) {
+			delete(d.Roles, ra)
+			delete(d.Owners, ra)
+		}
+	}
+	for ua := range d.Users {
+		if protected.Matches(ua) {
+			delete(d.Users, ua)
+			delete(d.Owners, ua)
+		}
+	}
+	for aid := range d.Accounts {
+		if protected.Matches(aid) {
+			delete(d.Accounts, aid)
+			delete(d.Owners, aid)
+		}
+	}
+}
+
+/*
+keep records the ARNs of the spec and status of an invalid snippet as kept,
+unless a snippet that precedes it owns them already.
+*/
+func (d *DesiredState) keep(snippet Snippet) {
+	key := snippetKey(snippet)
+	spec, status := snippet.GetSpec(), snippet.GetStatus()
+	arns := append(append([]string{}, status.RoleArns...), status.UserArns...)
+	for _, mr := range spec.MapRoles {
+		arns = append(arns, mr.RoleArn)
+	}
+	for _, mu := range spec.MapUsers {
+		arns = append(arns, mu.UserArn)
+	}
+	for _, arn := range arns {
+		if _, owned := d.Owners[arn]; !owned {
+			d.Kept[arn] = key
+		}
+	}
+}
+
+/*
+claim records the snippet as owner of the ARN unless another snippet already
+owns it or keeps it. In that case a conflict is recorded and false is
+returned.
+*/
+func (d *DesiredState) claim(arn, key string) bool {
+	owner, found := d.Owners[arn]
+	if kept, isKept := d.Kept[arn]; isKept && !found {
+		owner, found = kept, true
+	}
+	if found && owner != key {
+		d.Conflicts[key] = append(d.Conflicts[key], Conflict{Arn: arn, Winner: owner})
+		return false
+	}
+	d.Owners[arn] = key
+	return true
+}
@@ -0,0 +1,206 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around every change.
+const diffContext = 3
+
+/*
+MappingsDiff returns a unified diff of the mapRoles and mapUsers keys of the
+ConfigMap between the given mappings, serialized as they would be written. It
+returns an empty string if nothing differs.
+*/
+func MappingsDiff(before, after *Mappings) (string, error) {
+	rolesBefore, err := marshalMapRoles(before.Roles)
+	if err != nil {
+		return "", err
+	}
+	rolesAfter, err := marshalMapRoles(after.Roles)
+	if err != nil {
+		return "", err
+	}
+	usersBefore, err := marshalMapUsers(before.Users)
+	if err != nil {
+		return "", err
+	}
+	usersAfter, err := marshalMapUsers(after.Users)
+	if err != nil {
+		return "", err
+	}
+	return unifiedDiff(MAP_ROLES_KEY, string(rolesBefore), string(rolesAfter)) +
+		unifiedDiff(MAP_USERS_KEY, string(usersBefore), string(usersAfter)), nil
+}
+
+// diffOp is a single line of an edit script, prefixed with ' ', '-' or '+'.
+type diffOp struct {
+	kind byte
+	line string
+}
+
+/*
+unifiedDiff returns the difference between two texts in unified diff format,
+with both file names set to name. It returns an empty string if the texts are
+equal.
+*/
+func unifiedDiff(name, before, after string) string {
+	if before == after {
+		return ""
+	}
+	ops := diffLines(splitLines(before), splitLines(after))
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- a/%s\n+++ b/%s\n", name, name)
+	for start := 0; start < len(ops); {
+		// Find the next change and the end of its hunk, which extends as
+		// long as changes are no more than twice the context apart.
+		first := start
+		for first < len(ops) && ops[first].kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+		last := first
+		for i := first; i < len(ops) && i-last <= 2*diffContext; i++ {
+			if ops[i].kind != ' ' {
+				last = i
+			}
+		}
+		from, to := first-diffContext, last+diffContext+1
+		if from < start {
+			from = start
+		}
+		if to > len(ops) {
+			to = len(ops)
+		}
+
+		// Line numbers of the hunk in both texts
+		oldLine, newLine := 1, 1
+		for _, op := range ops[:from] {
+			if op.kind != '+' {
+				oldLine++
+			}
+			if op.kind != '-' {
+				newLine++
+			}
+		}
+		oldCount, newCount := 0, 0
+		for _, op := range ops[from:to] {
+			if op.kind != '+' {
+				oldCount++
+			}
+			if op.kind != '-' {
+				newCount++
+			}
+		}
+		if oldCount == 0 {
+			oldLine--
+		}
+		if newCount == 0 {
+			newLine--
+		}
+
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", oldLine, oldCount, newLine, newCount)
+		for _, op := range ops[from:to] {
+			sb.WriteByte(op.kind)
+			sb.WriteString(op.line)
+			sb.WriteByte('\n')
+		}
+		start = to
+	}
+	return sb.String()
+}
+
+/*
+maxDiffCells bounds the size of the table of diffLines. Larger differences
+are shown as replaced as a whole.
+*/
+const maxDiffCells = 1 << 18
+
+/*
+diffLines returns an edit script that turns a into b. The lines that both
+texts start and end with are kept. The shortest edit script of the lines in
+between is based on their longest common subsequence, unless its table would
+exceed maxDiffCells, then all of them are removed and added instead.
+*/
+func diffLines(a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := []diffOp{}
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	ops = append(ops, diffMiddle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+// diffMiddle returns the edit script of diffLines for the lines between the
+// common prefix and suffix.
+func diffMiddle(a, b []string) []diffOp {
+	ops := []diffOp{}
+	if (len(a)+1)*(len(b)+1) > maxDiffCells {
+		for _, line := range a {
+			ops = append(ops, diffOp{'-', line})
+		}
+		for _, line := range b {
+			ops = append(ops, diffOp{'+', line})
+		}
+		return ops
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case j == len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	return ops
+}
+
+// splitLines splits a text into lines without their line breaks.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	crdv1beta1 "github.com/inovex/aws-auth-controller/pkg/api/v1beta1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("unified diff", func() {
+	It("should be empty for equal texts", func() {
+		Expect(unifiedDiff("mapRoles", "a\nb\n", "a\nb\n")).To(BeEmpty())
+	})
+
+	It("should show changed lines with context", func() {
+		before := "1\n2\n3\n4\n5\n6\n7\n8\n"
+		after := "1\n2\n3\n4\nfive\n6\n7\n8\n"
+		Expect(unifiedDiff("mapRoles", before, after)).To(Equal(
+			"--- a/mapRoles\n+++ b/mapRoles\n" +
+				"@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n"))
+	})
+
+	It("should split distant changes into hunks", func() {
+		before := "a\n1\n2\n3\n4\n5\n6\n7\n8\nb\n"
+		after := "A\n1\n2\n3\n4\n5\n6\n7\n8\nB\n"
+		Expect(unifiedDiff("mapUsers", before, after)).To(Equal(
+			"--- a/mapUsers\n+++ b/mapUsers\n" +
+				"@@ -1,4 +1,4 @@\n-a\n+A\n 1\n 2\n 3\n" +
+				"@@ -7,4 +7,4 @@\n 6\n 7\n 8\n-b\n+B\n"))
+	})
+
+	It("should diff added text against an empty one", func() {
+		Expect(unifiedDiff("mapUsers", "", "a\n")).To(Equal(
+			"--- a/mapUsers\n+++ b/mapUsers\n@@ -0,0 +1,1 @@\n+a\n"))
+	})
+
+	It("should replace large differences as a whole", func() {
+		before, after := []string{}, []string{}
+		for i := 0; i < 1000; i++ {
+			before = append(before, fmt.Sprintf("before %d", i))
+			after = append(after, fmt.Sprintf("after %d", i))
+		}
+		ops := diffLines(append([]string{"same"}, before...), append([]string{"same"}, after...))
+		Expect(ops).To(HaveLen(2001))
+		Expect(ops[0]).To(Equal(diffOp{' ', "same"}))
+		Expect(ops[1]).To(Equal(diffOp{'-', "before 0"}))
+		Expect(ops[1001]).To(Equal(diffOp{'+', "after 0"}))
+	})
+
+	It("should diff the serialized mappings", func() {
+		const ROLE_ARN = "arn:aws:iam::123456789012:role/diff"
+		before := NewMappings()
+		after := NewMappings()
+		after.Roles[ROLE_ARN] = crdv1beta1.MapRolesSpec{
+			RoleArn: ROLE_ARN, UserName: "diff", Groups: []string{"diff-group"},
+		}
+
+		diff, err := MappingsDiff(before, after)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(diff).To(ContainSubstring("--- a/mapRoles"))
+		Expect(diff).To(ContainSubstring("+- groups:\n+  - diff-group\n"))
+		Expect(diff).To(ContainSubstring("+  rolearn: " + ROLE_ARN))
+		Expect(diff).ToNot(ContainSubstring("mapUsers"))
+	})
+})
+
+var _ = Describe("dry-run deletion", func() {
+	const ROLE_ARN = "arn:aws:iam::123456789012:role/dry-run"
+
+	It("should store the diff of the removal in the status", func() {
+		current := NewMappings()
+		current.Roles[ROLE_ARN] = newRole(ROLE_ARN, "dry-run", "foobar-group")
+		current.Owners[ROLE_ARN] = "default/dry-run"
+		snip := deleted(newSnippet("dry-run", "dry-run", ROLE_ARN))
+		snip.Annotations = map[string]string{DRY_RUN_ANNOTATION: "true"}
+		snip.Finalizers = []string{FINALIZER_NAME}
+		c := newFakeClient(newConfigMap(current), snip)
+		r := &AwsAuthMapSnippetReconciler{
+			Client:   c,
+			Recorder: record.NewFakeRecorder(10),
+			Backend:  &ConfigMapBackend{Client: c},
+		}
+
+		_, err := r.reconcileSnippet(context.Background(), snip)
+		Expect(err).ToNot(HaveOccurred())
+
+		stored := &crdv1beta1.AwsAuthMapSnippet{}
+		Expect(c.Get(context.Background(), client.ObjectKeyFromObject(snip), stored)).To(Succeed())
+		Expect(stored.Status.DryRunDiff).To(ContainSubstring("-  rolearn: " + ROLE_ARN))
+		Expect(stored.Finalizers).To(ContainElement(FINALIZER_NAME))
+		synced := meta.FindStatusCondition(stored.Status.Conditions, crdv1beta1.ConditionSynced)
+		Expect(synced.Reason).To(Equal(crdv1beta1.ReasonDryRun))
+	})
+})
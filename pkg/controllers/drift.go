@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+/*
+FindDrift compares the current mappings of a backend with the desired state
+and returns the ARNs and account IDs of all managed entries that are missing or
+were modified, by the key of the snippet they belong to.
+*/
+func FindDrift(current *Mappings, desired *DesiredState) map[string][]string {
+	drift := map[string][]string{}
+
+	for ra, mr := range desired.Roles {
+		cmr, ok := current.Roles[ra]
+		if !ok || !equality.Semantic.DeepEqual(cmr, mr) {
+			drift[desired.Owners[ra]] = append(drift[desired.Owners[ra]], ra)
+		}
+	}
+	for ua, mu := range desired.Users {
+		cmu, ok := current.Users[ua]
+		if !ok || !equality.Semantic.DeepEqual(cmu, mu) {
+			drift[desired.Owners[ua]] = append(drift[desired.Owners[ua]], ua)
+		}
+	}
+	for aid := range desired.Accounts {
+		if !current.Accounts[aid] {
+			drift[desired.Owners[aid]] = append(drift[desired.Owners[aid]], aid)
+		}
+	}
+	return drift
+}
+
+// markDrifted remembers ARNs and account IDs whose entries drifted, until a
+// write of the ConfigMap restores them.
+func (r *AwsAuthMapSnippetReconciler) markDrifted(arns []string) {
+	r.driftedMutex.Lock()
+	defer r.driftedMutex.Unlock()
+	if r.drifted == nil {
+		r.drifted = map[string]bool{}
+	}
+	for _, arn := range arns {
+		r.drifted[arn] = true
+	}
+}
+
+/*
+countDriftCorrections counts the drifted entries that were added or updated by
+a successful write of the ConfigMap. All drifted entries are forgotten
+afterwards, as the ConfigMap matches the desired state again.
+*/
+func (r *AwsAuthMapSnippetReconciler) countDriftCorrections(changes *Changes) {
+	r.driftedMutex.Lock()
+	defer r.driftedMutex.Unlock()
+	corrected := 0
+	for _, arn := range append(append([]string{}, changes.Added...), changes.Updated...) {
+		if r.drifted[arn] {
+			corrected++
+		}
+	}
+	if corrected > 0 {
+		driftCorrections.Add(float64(corrected))
+	}
+	r.drifted = nil
+}
@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	crdv1beta1 "github.com/inovex/aws-auth-controller/pkg/api/v1beta1"
+)
+
+// Reasons of the events that are recorded on snippets.
+const (
+	EventReasonMappingsAdded   = "MappingsAdded"
+	EventReasonMappingsUpdated = "MappingsUpdated"
+	EventReasonMappingsRemoved = "MappingsRemoved"
+	EventReasonWriteFailed     = "WriteFailed"
+	EventReasonConflict        = "Conflict"
+	EventReasonInvalid         = "Invalid"
+	EventReasonFinalized       = "Finalized"
+	EventReasonDryRun          = "DryRun"
+	EventReasonBlocked         = "Blocked"
+	EventReasonRejected        = "Rejected"
+)
+
+// warningConditions are the conditions that are reported in a warning event
+// with the given reason once they become true.
+var warningConditions = []struct{ condition, reason string }{
+	{crdv1beta1.ConditionInvalid, EventReasonInvalid},
+	{crdv1beta1.ConditionConflict, EventReasonConflict},
+	{crdv1beta1.ConditionRejected, EventReasonRejected},
+}
+
+// maxEventDiffLength limits the size of diffs in event messages. The full
+// diff is available in the status of the snippet.
+const maxEventDiffLength = 1024
+
+/*
+recordChanges records an event for every changed entry on the snippet it
+belongs to.
+
+Added and updated entries belong to the snippet that owns them in the desired
+state. Removed entries belong to every snippet that lists them in its spec or
+status, which includes snippets that are being deleted.
+*/
+func (r *AwsAuthMapSnippetReconciler) recordChanges(snippets []Snippet, desired *DesiredState, changes *Changes) {
+	byKey := map[string]Snippet{}
+	for _, snippet := range snippets {
+		byKey[snippetKey(snippet)] = snippet
+	}
+
+	added := map[string][]string{}
+	for _, arn := range changes.Added {
+		added[desired.Owners[arn]] = append(added[desired.Owners[arn]], arn)
+	}
+	updated := map[string][]string{}
+	for _, arn := range changes.Updated {
+		updated[desired.Owners[arn]] = append(updated[desired.Owners[arn]], arn)
+	}
+	adopted := map[string][]string{}
+	for _, arn := range changes.Adopted {
+		if owner := desired.Owners[arn]; desired.Adopting[owner] {
+			adopted[owner] = append(adopted[owner], arn)
+		}
+	}
+	removed := map[string][]string{}
+	for _, arn := range changes.Removed {
+		for _, snippet := range snippets {
+			if snippetDeclares(snippet, arn) {
+				removed[snippetKey(snippet)] = append(removed[snippetKey(snippet)], arn)
+			}
+		}
+	}
+
+	for key, snippet := range byKey {
+		if arns := added[key]; len(arns) > 0 {
+			r.Recorder.Event(snippet, corev1.EventTypeNormal, EventReasonMappingsAdded,
+				fmt.Sprintf("Added mappings for %s", strings.Join(arns, ", ")))
+		}
+		if arns := updated[key]; len(arns) > 0 {
+			r.Recorder.Event(snippet, corev1.EventTypeNormal, EventReasonMappingsUpdated,
+				fmt.Sprintf("Updated mappings for %s", strings.Join(arns, ", ")))
+		}
+		if arns := adopted[key]; len(arns) > 0 {
+			r.Recorder.Event(snippet, corev1.EventTypeNormal, EventReasonAdopted,
+				fmt.Sprintf("Adopted existing mappings for %s", strings.Join(arns, ", ")))
+		}
+		if arns := removed[key]; len(arns) > 0 {
+			r.Recorder.Event(snippet, corev1.EventTypeNormal, EventReasonMappingsRemoved,
+				fmt.Sprintf("Removed mappings for %s", strings.Join(arns, ", ")))
+		}
+	}
+}
+
+// snippetDeclares returns true if the ARN or account ID is part of the spec
+// or the status of the snippet.
+func snippetDeclares(snippet Snippet, arn string) bool {
+	spec, status := snippet.GetSpec(), snippet.GetStatus()
+	for _, mr := range spec.MapRoles {
+		if mr.RoleArn == arn {
+			return true
+		}
+	}
+	for _, mu := range spec.MapUsers {
+		if mu.UserArn == arn {
+			return true
+		}
+	}
+	for _, ma := range spec.MapAccounts {
+		if string(ma) == arn {
+			return true
+		}
+	}
+	return containsString(status.RoleArns, arn) ||
+		containsString(status.UserArns, arn) ||
+		containsString(status.Accounts, arn)
+}
+
+// dryRunEventMessage returns the message of the event that reports the diff
+// of a dry run.
+func dryRunEventMessage(diff string) string {
+	if diff == "" {
+		return "Dry run, the mappings would not change"
+	}
+	if len(diff) > maxEventDiffLength {
+		diff = diff[:maxEventDiffLength] + "\n... (truncated, see status.dryRunDiff)"
+	}
+	return "Dry run, the mappings would change:\n" + diff
+}
+
+/*
+recordConditionEvents records a warning event for every condition of
+warningConditions that is true now but was not in the previous conditions of
+the snippet, so that a snippet does not repeat the same warning on every
+reconciliation.
+*/
+func (r *AwsAuthMapSnippetReconciler) recordConditionEvents(snippet Snippet, previous []metav1.Condition) {
+	conditions := snippet.GetStatus().Conditions
+	for _, warning := range warningConditions {
+		c := meta.FindStatusCondition(conditions, warning.condition)
+		if c == nil || c.Status != metav1.ConditionTrue || meta.IsStatusConditionTrue(previous, warning.condition) {
+			continue
+		}
+		r.Recorder.Event(snippet, corev1.EventTypeWarning, warning.reason, c.Message)
+	}
+}
@@ -0,0 +1,46 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+var _ = Describe("condition events", func() {
+	const ROLE_ARN = "arn:aws:iam::123456789012:role/events"
+
+	It("should only warn when a condition becomes true", func() {
+		snip := newSnippet("events", "events", ROLE_ARN)
+		snip.Spec.MapRoles[0].Groups = nil
+		recorder := record.NewFakeRecorder(10)
+		r := &AwsAuthMapSnippetReconciler{
+			Client:   newFakeClient(snip),
+			Recorder: recorder,
+			Backend:  NewInMemoryBackend(),
+		}
+		// warnings syncs the snippet and returns the warnings it recorded.
+		warnings := func() []string {
+			Expect(r.syncConfigMap(context.Background(), snip)).To(Succeed())
+			events := []string{}
+			for len(recorder.Events) > 0 {
+				if event := <-recorder.Events; strings.HasPrefix(event, corev1.EventTypeWarning) {
+					events = append(events, event)
+				}
+			}
+			return events
+		}
+
+		Expect(warnings()).To(ConsistOf(HavePrefix("Warning Invalid")))
+		// The status still says invalid, so there is nothing new to report.
+		Expect(warnings()).To(BeEmpty())
+
+		snip.Spec.MapRoles[0].Groups = []string{"foobar-group"}
+		Expect(warnings()).To(BeEmpty())
+		snip.Spec.MapRoles[0].Groups = nil
+		Expect(warnings()).To(ConsistOf(HavePrefix("Warning Invalid")))
+	})
+})
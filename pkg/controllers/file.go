@@ -0,0 +1,277 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/yaml"
+
+	crdv1beta1 "github.com/inovex/aws-auth-controller/pkg/api/v1beta1"
+)
+
+/*
+FileBackend stores the mappings in the server config file of
+aws-iam-authenticator, e.g. on a volume shared with the authenticator:
+
+	server:
+	  mapRoles:
+	  - roleARN: arn:aws:iam::000000000000:role/KubernetesAdmin
+	    username: kubernetes-admin
+	    groups:
+	    - system:masters
+	  mapUsers: []
+	  mapAccounts: []
+
+All other settings in the file are kept. The file is replaced atomically, so
+the authenticator never reads a partially written file.
+
+The ownership index and the original entries taken over by snippets are kept
+in a separate file next to it, see StatePath, as the authenticator does not
+know about them.
+*/
+type FileBackend struct {
+	Path string
+
+	mutex sync.Mutex
+}
+
+var _ Backend = &FileBackend{}
+
+// fileMapRole and fileMapUser are the mappings as spelled in the config file.
+type fileMapRole struct {
+	RoleARN  string   `json:"roleARN"`
+	Username string   `json:"username"`
+	Groups   []string `json:"groups"`
+}
+
+type fileMapUser struct {
+	UserARN  string   `json:"userARN"`
+	Username string   `json:"username"`
+	Groups   []string `json:"groups"`
+}
+
+type fileServerConfig struct {
+	MapRoles    []fileMapRole `json:"mapRoles"`
+	MapUsers    []fileMapUser `json:"mapUsers"`
+	MapAccounts []string      `json:"mapAccounts"`
+}
+
+// fileState is the content of the file at StatePath.
+type fileState struct {
+	Owners    map[string]string        `json:"owners"`
+	Originals map[string]OriginalEntry `json:"originals,omitempty"`
+}
+
+// Name implements Backend.
+func (b *FileBackend) Name() string {
+	return BACKEND_FILE
+}
+
+// StatePath returns the path of the file that holds the ownership index and
+// the original entries.
+func (b *FileBackend) StatePath() string {
+	return b.Path + ".owners.json"
+}
+
+// Load implements Backend. A missing file is reported as empty mappings.
+func (b *FileBackend) Load(ctx context.Context) (*Mappings, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	mappings, _, err := b.read()
+	return mappings, err
+}
+
+// Apply implements Backend. The file is only written if the mappings changed.
+func (b *FileBackend) Apply(ctx context.Context, desired *DesiredState) (*Changes, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	current, config, err := b.read()
+	if err != nil {
+		return nil, err
+	}
+	after := current.Copy()
+	after.Merge(desired)
+	changes := diffMappings(current, after)
+
+	// The state is written first, so that the original entries are never
+	// lost if writing the config fails.
+	if err := b.writeState(current, after); err != nil {
+		return nil, err
+	}
+	if changes.Empty() {
+		return changes, nil
+	}
+
+	server, _ := config["server"].(map[string]interface{})
+	if server == nil {
+		server = map[string]interface{}{}
+	}
+	fileConfig := renderServerConfig(after)
+	server["mapRoles"] = fileConfig.MapRoles
+	server["mapUsers"] = fileConfig.MapUsers
+	server["mapAccounts"] = fileConfig.MapAccounts
+	config["server"] = server
+
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomically(b.Path, data); err != nil {
+		return nil, err
+	}
+	log.FromContext(ctx).Info("Wrote authenticator config", "path", b.Path)
+	return changes, nil
+}
+
+// read returns the mappings and the complete content of the config file.
+func (b *FileBackend) read() (*Mappings, map[string]interface{}, error) {
+	config := map[string]interface{}{}
+	data, err := os.ReadFile(b.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return NewMappings(), config, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, nil, fmt.Errorf("parsing %s: %w", b.Path, err)
+	}
+	if config == nil {
+		config = map[string]interface{}{}
+	}
+
+	// Round-trip the server section to get typed mappings.
+	fileConfig := fileServerConfig{}
+	serverYaml, err := yaml.Marshal(config["server"])
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := yaml.Unmarshal(serverYaml, &fileConfig); err != nil {
+		return nil, nil, fmt.Errorf("parsing server section of %s: %w", b.Path, err)
+	}
+
+	mappings := NewMappings()
+	for _, mr := range fileConfig.MapRoles {
+		mappings.Roles[mr.RoleARN] = crdv1beta1.MapRolesSpec{RoleArn: mr.RoleARN, UserName: mr.Username, Groups: mr.Groups}
+	}
+	for _, mu := range fileConfig.MapUsers {
+		mappings.Users[mu.UserARN] = crdv1beta1.MapUsersSpec{UserArn: mu.UserARN, UserName: mu.Username, Groups: mu.Groups}
+	}
+	for _, aid := range fileConfig.MapAccounts {
+		mappings.Accounts[aid] = true
+	}
+	if err := b.readState(mappings); err != nil {
+		return nil, nil, err
+	}
+	return mappings, config, nil
+}
+
+// readState reads the ownership index and the original entries into the
+// mappings. A missing file is treated as empty.
+func (b *FileBackend) readState(mappings *Mappings) error {
+	data, err := os.ReadFile(b.StatePath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	state := fileState{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("parsing %s: %w", b.StatePath(), err)
+	}
+	for arn, owner := range state.Owners {
+		mappings.Owners[arn] = owner
+	}
+	for arn, original := range state.Originals {
+		mappings.Originals[arn] = original
+	}
+	return nil
+}
+
+// writeState writes the ownership index and the original entries of after if
+// they differ from the ones of before.
+func (b *FileBackend) writeState(before, after *Mappings) error {
+	owners, originals := after.index()
+	previousOwners, previousOriginals := before.index()
+	if equality.Semantic.DeepEqual(owners, previousOwners) && equality.Semantic.DeepEqual(originals, previousOriginals) {
+		return nil
+	}
+	// Map keys are sorted, so the output is stable.
+	data, err := json.Marshal(fileState{Owners: owners, Originals: originals})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(b.StatePath(), data)
+}
+
+// renderServerConfig returns the mappings sorted by ARN so that the output is
+// stable.
+func renderServerConfig(mappings *Mappings) *fileServerConfig {
+	config := &fileServerConfig{
+		MapRoles:    []fileMapRole{},
+		MapUsers:    []fileMapUser{},
+		MapAccounts: []string{},
+	}
+	for _, mr := range mappings.Roles {
+		config.MapRoles = append(config.MapRoles, fileMapRole{RoleARN: mr.RoleArn, Username: mr.UserName, Groups: mr.Groups})
+	}
+	sort.Slice(config.MapRoles, func(i, j int) bool {
+		return config.MapRoles[i].RoleARN < config.MapRoles[j].RoleARN
+	})
+	for _, mu := range mappings.Users {
+		config.MapUsers = append(config.MapUsers, fileMapUser{UserARN: mu.UserArn, Username: mu.UserName, Groups: mu.Groups})
+	}
+	sort.Slice(config.MapUsers, func(i, j int) bool {
+		return config.MapUsers[i].UserARN < config.MapUsers[j].UserARN
+	})
+	for aid := range mappings.Accounts {
+		config.MapAccounts = append(config.MapAccounts, aid)
+	}
+	sort.Strings(config.MapAccounts)
+	return config
+}
+
+/*
+writeFileAtomically writes the data to a temporary file in the same directory
+and renames it to path. The permissions of an existing file are kept.
+*/
+func writeFileAtomically(path string, data []byte) error {
+	mode := fs.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	crdv1beta1 "github.com/inovex/aws-auth-controller/pkg/api/v1beta1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/yaml"
+)
+
+var _ = Describe("file backend", func() {
+	const (
+		ROLE_ARN = "arn:aws:iam::123456789012:role/file"
+		NODE_ARN = "arn:aws:iam::123456789012:role/node"
+	)
+
+	var path string
+
+	BeforeEach(func() {
+		path = filepath.Join(GinkgoT().TempDir(), "config.yaml")
+	})
+
+	snippet := func(userName string) *crdv1beta1.AwsAuthMapSnippet {
+		return newSnippet("file", userName, ROLE_ARN, "444455556666")
+	}
+
+	It("should render the mappings and keep other settings", func() {
+		Expect(os.WriteFile(path, []byte(`clusterID: my-cluster
+server:
+  port: 21362
+  mapRoles:
+  - roleARN: `+NODE_ARN+`
+    username: system:node:{{EC2PrivateDNSName}}
+    groups:
+    - system:nodes
+`), 0600)).To(Succeed())
+
+		backend := &FileBackend{Path: path}
+		changes, err := backend.Apply(context.Background(), ComputeDesiredState([]Snippet{snippet("file-name")}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes.Added).To(ConsistOf(ROLE_ARN, "444455556666"))
+
+		data, err := os.ReadFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		config := map[string]interface{}{}
+		Expect(yaml.Unmarshal(data, &config)).To(Succeed())
+		Expect(config).To(HaveKeyWithValue("clusterID", "my-cluster"))
+		Expect(config["server"]).To(HaveKeyWithValue("port", BeNumerically("==", 21362)))
+
+		info, err := os.Stat(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+
+		mappings, err := backend.Load(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mappings.Roles).To(HaveKey(NODE_ARN))
+		Expect(mappings.Roles[ROLE_ARN].UserName).To(Equal("file-name"))
+		Expect(mappings.Accounts).To(HaveKey("444455556666"))
+	})
+
+	It("should remove the mappings of deleted snippets", func() {
+		backend := &FileBackend{Path: path}
+		snip := snippet("file-name")
+		_, err := backend.Apply(context.Background(), ComputeDesiredState([]Snippet{snip}))
+		Expect(err).ToNot(HaveOccurred())
+
+		changes, err := backend.Apply(context.Background(), ComputeDesiredState([]Snippet{deleted(snip)}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes.Removed).To(ConsistOf(ROLE_ARN, "444455556666"))
+
+		mappings, err := backend.Load(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mappings.Roles).To(BeEmpty())
+
+		// No temporary files are left behind
+		entries, err := os.ReadDir(filepath.Dir(path))
+		Expect(err).ToNot(HaveOccurred())
+		names := []string{}
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		Expect(names).To(ConsistOf(filepath.Base(path), filepath.Base(backend.StatePath())))
+	})
+
+	It("should restore the original entry of a deleted snippet", func() {
+		Expect(os.WriteFile(path, []byte(`server:
+  mapRoles:
+  - roleARN: `+NODE_ARN+`
+    username: system:node:{{EC2PrivateDNSName}}
+    groups:
+    - system:nodes
+`), 0600)).To(Succeed())
+
+		backend := &FileBackend{Path: path}
+		snip := newSnippet("file", "file-name", NODE_ARN)
+		_, err := backend.Apply(context.Background(), ComputeDesiredState([]Snippet{snip}))
+		Expect(err).ToNot(HaveOccurred())
+
+		mappings, err := backend.Load(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mappings.Roles[NODE_ARN].UserName).To(Equal("file-name"))
+		Expect(mappings.Owners).To(HaveKeyWithValue(NODE_ARN, "default/file"))
+		Expect(mappings.Originals).To(HaveKey(NODE_ARN))
+
+		changes, err := backend.Apply(context.Background(), ComputeDesiredState([]Snippet{deleted(snip)}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes.Updated).To(ConsistOf(NODE_ARN))
+
+		mappings, err = backend.Load(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mappings.Roles[NODE_ARN].UserName).To(Equal("system:node:{{EC2PrivateDNSName}}"))
+		Expect(mappings.Owners).To(BeEmpty())
+		Expect(mappings.Originals).To(BeEmpty())
+	})
+})
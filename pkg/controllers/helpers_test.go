@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+
+	crdv1beta1 "github.com/inovex/aws-auth-controller/pkg/api/v1beta1"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/yaml"
+)
+
+// accountIDPattern tells account IDs apart from ARNs in newSnippet.
+var accountIDPattern = regexp.MustCompile(`^[0-9]{12}$`)
+
+// newFakeClient returns a fake client with the core types, the snippets and
+// IAMIdentityMappings in its scheme.
+func newFakeClient(objs ...client.Object) client.Client {
+	s := runtime.NewScheme()
+	Expect(clientgoscheme.AddToScheme(s)).To(Succeed())
+	Expect(crdv1beta1.AddToScheme(s)).To(Succeed())
+	s.AddKnownTypeWithName(IAMIdentityMappingGVK, &unstructured.Unstructured{})
+	s.AddKnownTypeWithName(IAMIdentityMappingGVK.GroupVersion().WithKind("IAMIdentityMappingList"), &unstructured.UnstructuredList{})
+	return fake.NewClientBuilder().WithScheme(s).WithObjects(objs...).Build()
+}
+
+/*
+newSnippet returns a snippet in the default namespace that maps every ARN to
+the user name and foobar-group. Role ARNs become role mappings, account IDs
+account mappings and all other ARNs user mappings.
+*/
+func newSnippet(name, userName string, arns ...string) *crdv1beta1.AwsAuthMapSnippet {
+	snip := &crdv1beta1.AwsAuthMapSnippet{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+	}
+	for _, arn := range arns {
+		switch {
+		case accountIDPattern.MatchString(arn):
+			snip.Spec.MapAccounts = append(snip.Spec.MapAccounts, crdv1beta1.AccountID(arn))
+		case isRoleArn(arn):
+			snip.Spec.MapRoles = append(snip.Spec.MapRoles, crdv1beta1.MapRolesSpec{
+				RoleArn: arn, UserName: userName, Groups: []string{"foobar-group"},
+			})
+		default:
+			snip.Spec.MapUsers = append(snip.Spec.MapUsers, crdv1beta1.MapUsersSpec{
+				UserArn: arn, UserName: userName, Groups: []string{"foobar-group"},
+			})
+		}
+	}
+	return snip
+}
+
+// deleted marks the snippet as being deleted and returns it.
+func deleted(snip *crdv1beta1.AwsAuthMapSnippet) *crdv1beta1.AwsAuthMapSnippet {
+	now := metav1.Now()
+	snip.DeletionTimestamp = &now
+	return snip
+}
+
+// newRole returns a role mapping of the ARN.
+func newRole(arn, userName string, groups ...string) crdv1beta1.MapRolesSpec {
+	return crdv1beta1.MapRolesSpec{RoleArn: arn, UserName: userName, Groups: groups}
+}
+
+// newUser returns a user mapping of the ARN.
+func newUser(arn, userName string, groups ...string) crdv1beta1.MapUsersSpec {
+	return crdv1beta1.MapUsersSpec{UserArn: arn, UserName: userName, Groups: groups}
+}
+
+/*
+newConfigMap returns the aws-auth ConfigMap holding the mappings, including
+the ownership index and the originals if there are any, as written by the
+controller.
+*/
+func newConfigMap(mappings *Mappings) *corev1.ConfigMap {
+	mapRoles, err := marshalMapRoles(mappings.Roles)
+	Expect(err).ToNot(HaveOccurred())
+	mapUsers, err := marshalMapUsers(mappings.Users)
+	Expect(err).ToNot(HaveOccurred())
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   CONFIG_MAP_NAMESPACE,
+			Name:        CONFIG_MAP_NAME,
+			Annotations: map[string]string{},
+		},
+		Data: map[string]string{
+			MAP_ROLES_KEY: string(mapRoles),
+			MAP_USERS_KEY: string(mapUsers),
+		},
+	}
+	if len(mappings.Accounts) > 0 {
+		mapAccounts := MapAccounts{}
+		for aid := range mappings.Accounts {
+			mapAccounts = append(mapAccounts, aid)
+		}
+		sort.Strings(mapAccounts)
+		data, err := yaml.Marshal(mapAccounts)
+		Expect(err).ToNot(HaveOccurred())
+		cm.Data[MAP_ACCOUNTS_KEY] = string(data)
+	}
+	if len(mappings.Owners) > 0 {
+		data, err := json.Marshal(mappings.Owners)
+		Expect(err).ToNot(HaveOccurred())
+		cm.Annotations[OWNERS_ANNOTATION] = string(data)
+	}
+	if len(mappings.Originals) > 0 {
+		data, err := json.Marshal(mappings.Originals)
+		Expect(err).ToNot(HaveOccurred())
+		cm.Annotations[ORIGINALS_ANNOTATION] = string(data)
+	}
+	return cm
+}
@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Labels and annotations of the snapshots of the ConfigMap.
+const (
+	HISTORY_LABEL              = "awsauth.io/history"
+	HISTORY_TIMESTAMP_LABEL    = "awsauth.io/timestamp"
+	HISTORY_SNIPPET_LABEL      = "awsauth.io/snippet"
+	HISTORY_HASH_LABEL         = "awsauth.io/content-hash"
+	HISTORY_SNIPPET_ANNOTATION = "awsauth.io/snippet"
+)
+
+// historyTimestampFormat is used for the timestamp label, it sorts in
+// chronological order.
+const historyTimestampFormat = "20060102T150405.000000Z"
+
+/*
+History keeps a bounded ring of snapshots of the mapRoles and mapUsers of the
+ConfigMap, together with its ownership index and original entries. Every
+snapshot is a ConfigMap in Namespace, labelled with the time it was taken, the
+snippet that triggered the write and a hash of its content.
+*/
+type History struct {
+	// Client creates and deletes the snapshots.
+	Client client.Client
+	// Reader reads the snapshots. The cache of the manager only holds the
+	// aws-auth ConfigMap, so this should be the API reader of the manager.
+	Reader client.Reader
+	// Namespace is where the snapshots are stored, usually the namespace of
+	// the controller.
+	Namespace string
+	// Size is the number of snapshots that are kept.
+	Size int
+}
+
+type triggerKey struct{}
+
+// WithTrigger returns a context that names the snippet or other object that
+// triggers the writes done with it. The name is recorded in the snapshots.
+func WithTrigger(ctx context.Context, trigger string) context.Context {
+	return context.WithValue(ctx, triggerKey{}, trigger)
+}
+
+// triggerFrom returns the trigger stored by WithTrigger, or an empty string.
+func triggerFrom(ctx context.Context) string {
+	trigger, _ := ctx.Value(triggerKey{}).(string)
+	return trigger
+}
+
+/*
+Snapshot stores the mapRoles and mapUsers of the given ConfigMap and the
+annotations that hold its ownership index and original entries. It is skipped
+if the latest snapshot has the same content. The oldest snapshots are deleted
+so that no more than Size snapshots are kept.
+*/
+func (h *History) Snapshot(ctx context.Context, cm *corev1.ConfigMap) error {
+	data := map[string]string{
+		MAP_ROLES_KEY: cm.Data[MAP_ROLES_KEY],
+		MAP_USERS_KEY: cm.Data[MAP_USERS_KEY],
+	}
+	trigger := triggerFrom(ctx)
+	annotations := map[string]string{
+		HISTORY_SNIPPET_ANNOTATION: trigger,
+	}
+	for _, key := range []string{OWNERS_ANNOTATION, ORIGINALS_ANNOTATION} {
+		if value, ok := cm.Annotations[key]; ok {
+			annotations[key] = value
+		}
+	}
+	hash := contentHash(data, annotations)
+
+	snapshots, err := h.List(ctx)
+	if err != nil {
+		return err
+	}
+	if len(snapshots) > 0 && snapshots[0].Labels[HISTORY_HASH_LABEL] == hash {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	snapshot := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-%s", cm.Name, now.Format("20060102-150405.000000")),
+			Namespace: h.Namespace,
+			Labels: map[string]string{
+				HISTORY_LABEL:           cm.Name,
+				HISTORY_TIMESTAMP_LABEL: now.Format(historyTimestampFormat),
+				HISTORY_SNIPPET_LABEL:   snippetLabelValue(trigger),
+				HISTORY_HASH_LABEL:      hash,
+			},
+			Annotations: annotations,
+		},
+		Data: data,
+	}
+	if err := h.Client.Create(ctx, snapshot); err != nil {
+		return fmt.Errorf("creating snapshot %s: %w", snapshot.Name, err)
+	}
+	log.FromContext(ctx).Info("Stored snapshot of ConfigMap", "snapshot", snapshot.Name, "snippet", trigger)
+
+	// The new snapshot is not part of the list.
+	for i := h.Size - 1; i >= 0 && i < len(snapshots); i++ {
+		if err := h.Client.Delete(ctx, &snapshots[i]); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("deleting snapshot %s: %w", snapshots[i].Name, err)
+		}
+	}
+	return nil
+}
+
+// List returns all snapshots, the latest first.
+func (h *History) List(ctx context.Context) ([]corev1.ConfigMap, error) {
+	list := &corev1.ConfigMapList{}
+	err := h.Reader.List(ctx, list, client.InNamespace(h.Namespace), client.HasLabels{HISTORY_LABEL})
+	if err != nil {
+		return nil, err
+	}
+	snapshots := list.Items
+	sort.Slice(snapshots, func(i, j int) bool {
+		ti, tj := snapshots[i].Labels[HISTORY_TIMESTAMP_LABEL], snapshots[j].Labels[HISTORY_TIMESTAMP_LABEL]
+		if ti != tj {
+			return ti > tj
+		}
+		return snapshots[i].Name > snapshots[j].Name
+	})
+	return snapshots, nil
+}
+
+// Get returns the snapshot with the given name.
+func (h *History) Get(ctx context.Context, name string) (*corev1.ConfigMap, error) {
+	snapshot := &corev1.ConfigMap{}
+	err := h.Reader.Get(ctx, client.ObjectKey{Namespace: h.Namespace, Name: name}, snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := snapshot.Labels[HISTORY_LABEL]; !ok {
+		return nil, apierrs.NewNotFound(corev1.Resource("configmaps"), name)
+	}
+	return snapshot, nil
+}
+
+// contentHash returns a short hash of the data and the ownership annotations
+// of a snapshot.
+func contentHash(data, annotations map[string]string) string {
+	sum := sha256.Sum256([]byte(data[MAP_ROLES_KEY] + "\x00" + data[MAP_USERS_KEY] + "\x00" +
+		annotations[OWNERS_ANNOTATION] + "\x00" + annotations[ORIGINALS_ANNOTATION]))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+/*
+snippetLabelValue turns a snippet key into a valid label value. The slash is
+replaced by a dot, which cannot be part of a namespace, and the value is
+shortened to the maximum length. The full key is kept in an annotation.
+*/
+func snippetLabelValue(key string) string {
+	value := strings.ReplaceAll(key, "/", ".")
+	if len(value) > validation.LabelValueMaxLength {
+		value = value[:validation.LabelValueMaxLength]
+	}
+	return strings.TrimRight(value, "-_.")
+}
@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"context"
+
+	crdv1beta1 "github.com/inovex/aws-auth-controller/pkg/api/v1beta1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("ConfigMap history", func() {
+	const USER_ARN = "arn:aws:iam::123456789012:user/history"
+
+	var (
+		c       client.Client
+		history *History
+		backend *ConfigMapBackend
+	)
+
+	BeforeEach(func() {
+		c = newFakeClient()
+		history = &History{Client: c, Reader: c, Namespace: "aws-auth-controller", Size: 2}
+		backend = &ConfigMapBackend{Client: c, History: history}
+	})
+
+	apply := func(userName string) {
+		snip := newSnippet("history", userName, USER_ARN)
+		ctx := WithTrigger(context.Background(), snippetKey(snip))
+		_, err := backend.Apply(ctx, ComputeDesiredState([]Snippet{snip}))
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	It("should snapshot the previous content before every write", func() {
+		apply("first")
+		apply("second")
+
+		snapshots, err := history.List(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(snapshots).To(HaveLen(2))
+		Expect(snapshots[0].Data[MAP_USERS_KEY]).To(ContainSubstring("username: first"))
+		Expect(snapshots[1].Data[MAP_USERS_KEY]).To(BeEmpty())
+		Expect(snapshots[0].Labels).To(HaveKeyWithValue(HISTORY_LABEL, CONFIG_MAP_NAME))
+		Expect(snapshots[0].Labels).To(HaveKeyWithValue(HISTORY_SNIPPET_LABEL, "default.history"))
+		Expect(snapshots[0].Labels).To(HaveKeyWithValue(HISTORY_HASH_LABEL, contentHash(snapshots[0].Data, snapshots[0].Annotations)))
+		Expect(snapshots[0].Annotations).To(HaveKeyWithValue(HISTORY_SNIPPET_ANNOTATION, "default/history"))
+	})
+
+	It("should keep a bounded number of snapshots", func() {
+		apply("first")
+		apply("second")
+		apply("third")
+
+		snapshots, err := history.List(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(snapshots).To(HaveLen(2))
+		Expect(snapshots[0].Data[MAP_USERS_KEY]).To(ContainSubstring("username: second"))
+		Expect(snapshots[1].Data[MAP_USERS_KEY]).To(ContainSubstring("username: first"))
+	})
+
+	It("should not snapshot unchanged content", func() {
+		apply("first")
+		apply("first")
+
+		snapshots, err := history.List(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(snapshots).To(HaveLen(1))
+	})
+
+	It("should restore the ownership with the entries", func() {
+		apply("first")
+		snip := newSnippet("history", "first", USER_ARN)
+		_, err := backend.Apply(context.Background(), ComputeDesiredState([]Snippet{deleted(snip)}))
+		Expect(err).ToNot(HaveOccurred())
+
+		snapshots, err := history.List(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(snapshots[0].Annotations).To(HaveKey(OWNERS_ANNOTATION))
+		r := &AwsAuthMapRestoreReconciler{&AwsAuthMapSnippetReconciler{Client: c, Backend: backend}}
+		restore := &crdv1beta1.AwsAuthMapRestore{Spec: crdv1beta1.AwsAuthMapRestoreSpec{Snapshot: snapshots[0].Name}}
+		Expect(r.restoreSnapshot(context.Background(), restore)).To(Succeed())
+
+		mappings, err := backend.Load(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mappings.Users[USER_ARN].UserName).To(Equal("first"))
+		Expect(mappings.Owners).To(HaveKeyWithValue(USER_ARN, "default/history"))
+	})
+
+	It("should keep the owners of accounts added after the snapshot", func() {
+		const ACCOUNT_ID = "123456789012"
+		apply("first")
+		snip := newSnippet("history", "second", USER_ARN)
+		snip.Spec.MapAccounts = []crdv1beta1.AccountID{ACCOUNT_ID}
+		_, err := backend.Apply(context.Background(), ComputeDesiredState([]Snippet{snip}))
+		Expect(err).ToNot(HaveOccurred())
+
+		snapshots, err := history.List(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		r := &AwsAuthMapRestoreReconciler{&AwsAuthMapSnippetReconciler{Client: c, Backend: backend}}
+		restore := &crdv1beta1.AwsAuthMapRestore{Spec: crdv1beta1.AwsAuthMapRestoreSpec{Snapshot: snapshots[0].Name}}
+		Expect(r.restoreSnapshot(context.Background(), restore)).To(Succeed())
+
+		mappings, err := backend.Load(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mappings.Users[USER_ARN].UserName).To(Equal("first"))
+		Expect(mappings.Accounts).To(HaveKey(ACCOUNT_ID))
+		Expect(mappings.Owners).To(HaveKeyWithValue(ACCOUNT_ID, "default/history"))
+	})
+
+	It("should get snapshots by name", func() {
+		apply("first")
+		snapshots, err := history.List(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+
+		snapshot, err := history.Get(context.Background(), snapshots[0].Name)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(snapshot.Labels[HISTORY_HASH_LABEL]).To(Equal(snapshots[0].Labels[HISTORY_HASH_LABEL]))
+
+		_, err = history.Get(context.Background(), "unknown")
+		Expect(err).To(HaveOccurred())
+	})
+})
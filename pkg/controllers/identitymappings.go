@@ -0,0 +1,287 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	crdv1beta1 "github.com/inovex/aws-auth-controller/pkg/api/v1beta1"
+)
+
+// IAMIdentityMappingGVK is the kind read by aws-iam-authenticator in CRD mode.
+var IAMIdentityMappingGVK = schema.GroupVersionKind{
+	Group:   "iamauthenticator.k8s.aws",
+	Version: "v1alpha1",
+	Kind:    "IAMIdentityMapping",
+}
+
+// Labels and annotations of the IAMIdentityMappings created by the controller.
+const (
+	IDENTITY_MAPPING_MANAGED_LABEL         = "awsauth.io/managed"
+	IDENTITY_MAPPING_OWNER_NAMESPACE_LABEL = "awsauth.io/owner-namespace"
+	IDENTITY_MAPPING_OWNER_NAME_LABEL      = "awsauth.io/owner-name"
+	IDENTITY_MAPPING_OWNER_ANNOTATION      = "awsauth.io/owner"
+	// IDENTITY_MAPPING_ORIGINAL_ANNOTATION holds the mapping that a snippet
+	// took over as JSON, see OriginalEntry.
+	IDENTITY_MAPPING_ORIGINAL_ANNOTATION = "awsauth.io/original"
+)
+
+/*
+IdentityMappingsBackend stores the mappings as IAMIdentityMapping objects,
+which aws-iam-authenticator reads in CRD mode instead of the aws-auth
+ConfigMap.
+
+Every role and user mapping becomes one cluster-scoped object named after a
+hash of its ARN. The objects are labelled with the namespace and name of the
+snippet that owns them and are deleted through the finalizer of the snippet.
+Objects without the managed label are updated if a snippet declares their ARN
+but never deleted. Their previous mapping is kept in an annotation and put
+back once no snippet declares the ARN anymore. Account mappings are not
+supported and ignored.
+*/
+type IdentityMappingsBackend struct {
+	client.Client
+}
+
+var _ Backend = &IdentityMappingsBackend{}
+
+//+kubebuilder:rbac:groups=iamauthenticator.k8s.aws,resources=iamidentitymappings,verbs=get;list;watch;create;update;patch;delete
+
+// Name implements Backend.
+func (b *IdentityMappingsBackend) Name() string {
+	return BACKEND_IDENTITY_MAPPINGS
+}
+
+// Load implements Backend.
+func (b *IdentityMappingsBackend) Load(ctx context.Context) (*Mappings, error) {
+	mappings, _, err := b.load(ctx)
+	return mappings, err
+}
+
+// load returns the mappings and the objects they were read from, by ARN.
+func (b *IdentityMappingsBackend) load(ctx context.Context) (*Mappings, map[string]*unstructured.Unstructured, error) {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(IAMIdentityMappingGVK.GroupVersion().WithKind(IAMIdentityMappingGVK.Kind + "List"))
+	if err := b.List(ctx, list); err != nil {
+		return nil, nil, err
+	}
+
+	mappings := NewMappings()
+	objects := map[string]*unstructured.Unstructured{}
+	for i := range list.Items {
+		obj := &list.Items[i]
+		arn, _, _ := unstructured.NestedString(obj.Object, "spec", "arn")
+		userName, _, _ := unstructured.NestedString(obj.Object, "spec", "username")
+		groups, _, _ := unstructured.NestedStringSlice(obj.Object, "spec", "groups")
+		if arn == "" {
+			continue
+		}
+		if _, found := objects[arn]; found {
+			// Prefer the object created by the controller.
+			if obj.GetLabels()[IDENTITY_MAPPING_MANAGED_LABEL] != "true" {
+				continue
+			}
+		}
+		objects[arn] = obj
+		delete(mappings.Owners, arn)
+		delete(mappings.Originals, arn)
+		if owner := obj.GetAnnotations()[IDENTITY_MAPPING_OWNER_ANNOTATION]; owner != "" {
+			mappings.Owners[arn] = owner
+		}
+		if data, ok := obj.GetAnnotations()[IDENTITY_MAPPING_ORIGINAL_ANNOTATION]; ok {
+			original := OriginalEntry{}
+			if err := json.Unmarshal([]byte(data), &original); err != nil {
+				return nil, nil, fmt.Errorf("parsing annotation %s of %s: %w", IDENTITY_MAPPING_ORIGINAL_ANNOTATION, obj.GetName(), err)
+			}
+			mappings.Originals[arn] = original
+		}
+		if isRoleArn(arn) {
+			mappings.Roles[arn] = crdv1beta1.MapRolesSpec{RoleArn: arn, UserName: userName, Groups: groups}
+		} else {
+			mappings.Users[arn] = crdv1beta1.MapUsersSpec{UserArn: arn, UserName: userName, Groups: groups}
+		}
+	}
+	return mappings, objects, nil
+}
+
+/*
+Apply implements Backend.
+
+Objects whose owner or original mapping changed, e.g. because another snippet
+took over the ARN, are updated even if the mapping itself did not change.
+
+If writing one of the objects fails the remaining ones are still written, the
+failed ones are returned as an aggregated error together with the changes
+that succeeded. The next reconciliation retries them.
+*/
+func (b *IdentityMappingsBackend) Apply(ctx context.Context, desired *DesiredState) (*Changes, error) {
+	logger := log.FromContext(ctx)
+	if len(desired.Accounts) > 0 {
+		logger.Info("Account mappings are not supported by IAMIdentityMappings, ignoring them", "backend", b.Name())
+	}
+
+	current, objects, err := b.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	after := current.Copy()
+	after.Merge(desired)
+	after.Accounts = MapAccountsByID{}
+	diff := diffMappings(current, after)
+
+	changes := &Changes{Adopted: diff.Adopted, Mappings: after}
+	errs := []error{}
+	failed := func(arn string, err error) {
+		after.revert(current, arn)
+		errs = append(errs, err)
+	}
+	for _, arn := range diff.Added {
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(IAMIdentityMappingGVK)
+		obj.SetName(identityMappingName(arn))
+		if err := b.setMapping(obj, after, arn); err != nil {
+			failed(arn, err)
+			continue
+		}
+		if err := b.Create(ctx, obj); err != nil {
+			failed(arn, fmt.Errorf("creating IAMIdentityMapping for %s: %w", arn, err))
+			continue
+		}
+		changes.Added = append(changes.Added, arn)
+	}
+
+	for _, arn := range diff.Removed {
+		obj := objects[arn]
+		delete(objects, arn)
+		if obj.GetLabels()[IDENTITY_MAPPING_MANAGED_LABEL] != "true" {
+			logger.Info("Not deleting IAMIdentityMapping that was not created by the controller", "arn", arn, "name", obj.GetName())
+			// Keep the mapping, but release it.
+			after.revert(current, arn)
+			delete(after.Owners, arn)
+			delete(after.Originals, arn)
+			if err := b.setMapping(obj, after, arn); err != nil {
+				failed(arn, err)
+				continue
+			}
+			if err := b.Update(ctx, obj); err != nil {
+				failed(arn, fmt.Errorf("updating IAMIdentityMapping for %s: %w", arn, err))
+			}
+			continue
+		}
+		if err := b.Delete(ctx, obj); client.IgnoreNotFound(err) != nil {
+			failed(arn, fmt.Errorf("deleting IAMIdentityMapping for %s: %w", arn, err))
+			continue
+		}
+		changes.Removed = append(changes.Removed, arn)
+	}
+
+	updated := map[string]bool{}
+	for _, arn := range diff.Updated {
+		updated[arn] = true
+	}
+	for arn, obj := range objects {
+		annotations := obj.GetAnnotations()
+		_, hadOriginal := annotations[IDENTITY_MAPPING_ORIGINAL_ANNOTATION]
+		_, hasOriginal := after.Originals[arn]
+		ownerChanged := annotations[IDENTITY_MAPPING_OWNER_ANNOTATION] != after.Owners[arn]
+		if !updated[arn] && !ownerChanged && hadOriginal == hasOriginal {
+			continue
+		}
+		if err := b.setMapping(obj, after, arn); err != nil {
+			failed(arn, err)
+			continue
+		}
+		if err := b.Update(ctx, obj); err != nil {
+			failed(arn, fmt.Errorf("updating IAMIdentityMapping for %s: %w", arn, err))
+			continue
+		}
+		if updated[arn] {
+			changes.Updated = append(changes.Updated, arn)
+		}
+	}
+	sort.Strings(changes.Updated)
+	return changes, utilerrors.NewAggregate(errs)
+}
+
+/*
+setMapping stores the mapping with the given ARN in the spec of the object,
+labels the object with its owner and annotates it with the original mapping.
+
+Objects that were not created by the controller keep their labels, so that
+they are not deleted later on.
+*/
+func (b *IdentityMappingsBackend) setMapping(obj *unstructured.Unstructured, mappings *Mappings, arn string) error {
+	owner := mappings.Owners[arn]
+	userName, groups := mappingOf(mappings, arn)
+	spec := map[string]interface{}{
+		"arn":      arn,
+		"username": userName,
+		"groups":   stringsToInterfaces(groups),
+	}
+	if err := unstructured.SetNestedMap(obj.Object, spec, "spec"); err != nil {
+		return err
+	}
+
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	delete(annotations, IDENTITY_MAPPING_OWNER_ANNOTATION)
+	delete(annotations, IDENTITY_MAPPING_ORIGINAL_ANNOTATION)
+	if owner != "" {
+		annotations[IDENTITY_MAPPING_OWNER_ANNOTATION] = owner
+	}
+	if original, ok := mappings.Originals[arn]; ok && owner != "" {
+		data, err := json.Marshal(original)
+		if err != nil {
+			return err
+		}
+		annotations[IDENTITY_MAPPING_ORIGINAL_ANNOTATION] = string(data)
+	}
+	obj.SetAnnotations(annotations)
+
+	if obj.GetResourceVersion() == "" || obj.GetLabels()[IDENTITY_MAPPING_MANAGED_LABEL] == "true" {
+		labels := obj.GetLabels()
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[IDENTITY_MAPPING_MANAGED_LABEL] = "true"
+		namespace, name := splitSnippetKey(owner)
+		delete(labels, IDENTITY_MAPPING_OWNER_NAMESPACE_LABEL)
+		if namespace != "" {
+			labels[IDENTITY_MAPPING_OWNER_NAMESPACE_LABEL] = namespace
+		}
+		// Snippet names may be longer than label values, the annotation
+		// always holds the complete owner.
+		delete(labels, IDENTITY_MAPPING_OWNER_NAME_LABEL)
+		if len(validation.IsValidLabelValue(name)) == 0 {
+			labels[IDENTITY_MAPPING_OWNER_NAME_LABEL] = name
+		}
+		obj.SetLabels(labels)
+	}
+	return nil
+}
+
+// identityMappingName derives a valid object name from an ARN.
+func identityMappingName(arn string) string {
+	sum := sha256.Sum256([]byte(arn))
+	return "awsauth-" + hex.EncodeToString(sum[:])[:16]
+}
+
+func stringsToInterfaces(strings []string) []interface{} {
+	result := make([]interface{}, 0, len(strings))
+	for _, s := range strings {
+		result = append(result, s)
+	}
+	return result
+}
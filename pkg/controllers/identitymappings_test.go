@@ -0,0 +1,145 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("IAMIdentityMapping backend", func() {
+	const (
+		ROLE_ARN = "arn:aws:iam::123456789012:role/identity-mapping"
+		NODE_ARN = "arn:aws:iam::123456789012:role/node"
+	)
+
+	var (
+		c       client.Client
+		backend *IdentityMappingsBackend
+	)
+
+	BeforeEach(func() {
+		c = newFakeClient()
+		backend = &IdentityMappingsBackend{Client: c}
+	})
+
+	snippet := newSnippet
+
+	getMapping := func(arn string) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(IAMIdentityMappingGVK)
+		Expect(c.Get(context.Background(), client.ObjectKey{Name: identityMappingName(arn)}, obj)).To(Succeed())
+		return obj
+	}
+
+	It("should maintain one owner-labelled object per mapping", func() {
+		snip := snippet("owner", "first", ROLE_ARN)
+		changes, err := backend.Apply(context.Background(), ComputeDesiredState([]Snippet{snip}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes.Added).To(ConsistOf(ROLE_ARN))
+
+		obj := getMapping(ROLE_ARN)
+		Expect(obj.GetLabels()).To(HaveKeyWithValue(IDENTITY_MAPPING_MANAGED_LABEL, "true"))
+		Expect(obj.GetLabels()).To(HaveKeyWithValue(IDENTITY_MAPPING_OWNER_NAMESPACE_LABEL, "default"))
+		Expect(obj.GetLabels()).To(HaveKeyWithValue(IDENTITY_MAPPING_OWNER_NAME_LABEL, "owner"))
+		userName, _, _ := unstructured.NestedString(obj.Object, "spec", "username")
+		Expect(userName).To(Equal("first"))
+
+		snip = snippet("owner", "second", ROLE_ARN)
+		changes, err = backend.Apply(context.Background(), ComputeDesiredState([]Snippet{snip}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes.Updated).To(ConsistOf(ROLE_ARN))
+		mappings, err := backend.Load(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mappings.Roles[ROLE_ARN].UserName).To(Equal("second"))
+
+		changes, err = backend.Apply(context.Background(), ComputeDesiredState([]Snippet{deleted(snip)}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes.Removed).To(ConsistOf(ROLE_ARN))
+		mappings, err = backend.Load(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mappings.Roles).To(BeEmpty())
+	})
+
+	It("should restore objects it did not create", func() {
+		node := &unstructured.Unstructured{}
+		node.SetGroupVersionKind(IAMIdentityMappingGVK)
+		node.SetName("node")
+		Expect(unstructured.SetNestedMap(node.Object, map[string]interface{}{
+			"arn":      NODE_ARN,
+			"username": "system:node:{{EC2PrivateDNSName}}",
+			"groups":   []interface{}{"system:nodes"},
+		}, "spec")).To(Succeed())
+		Expect(c.Create(context.Background(), node)).To(Succeed())
+
+		snip := snippet("node", "node", NODE_ARN)
+		changes, err := backend.Apply(context.Background(), ComputeDesiredState([]Snippet{snip}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes.Updated).To(ConsistOf(NODE_ARN))
+
+		Expect(c.Get(context.Background(), client.ObjectKeyFromObject(node), node)).To(Succeed())
+		Expect(node.GetAnnotations()).To(HaveKeyWithValue(IDENTITY_MAPPING_OWNER_ANNOTATION, "default/node"))
+		Expect(node.GetAnnotations()).To(HaveKey(IDENTITY_MAPPING_ORIGINAL_ANNOTATION))
+		Expect(node.GetLabels()).ToNot(HaveKey(IDENTITY_MAPPING_MANAGED_LABEL))
+
+		changes, err = backend.Apply(context.Background(), ComputeDesiredState([]Snippet{deleted(snip)}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes.Removed).To(BeEmpty())
+		Expect(changes.Updated).To(ConsistOf(NODE_ARN))
+
+		Expect(c.Get(context.Background(), client.ObjectKeyFromObject(node), node)).To(Succeed())
+		userName, _, _ := unstructured.NestedString(node.Object, "spec", "username")
+		Expect(userName).To(Equal("system:node:{{EC2PrivateDNSName}}"))
+		Expect(node.GetAnnotations()).ToNot(HaveKey(IDENTITY_MAPPING_OWNER_ANNOTATION))
+		Expect(node.GetAnnotations()).ToNot(HaveKey(IDENTITY_MAPPING_ORIGINAL_ANNOTATION))
+	})
+
+	It("should write the remaining objects if one of them fails", func() {
+		const USER_ARN = "arn:aws:iam::123456789012:user/identity-mapping"
+		failing := &failingClient{Client: c, names: map[string]bool{identityMappingName(ROLE_ARN): true}}
+		backend.Client = failing
+
+		snip := snippet("owner", "first", ROLE_ARN, USER_ARN)
+		changes, err := backend.Apply(context.Background(), ComputeDesiredState([]Snippet{snip}))
+		Expect(err).To(MatchError(ContainSubstring(ROLE_ARN)))
+		Expect(changes.Added).To(ConsistOf(USER_ARN))
+		Expect(changes.Mappings.Roles).ToNot(HaveKey(ROLE_ARN))
+		Expect(getMapping(USER_ARN).GetLabels()).To(HaveKeyWithValue(IDENTITY_MAPPING_MANAGED_LABEL, "true"))
+
+		// The next reconciliation retries the failed object.
+		failing.names = nil
+		changes, err = backend.Apply(context.Background(), ComputeDesiredState([]Snippet{snip}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes.Added).To(ConsistOf(ROLE_ARN))
+	})
+})
+
+// failingClient fails to write the objects with the given names.
+type failingClient struct {
+	client.Client
+	names map[string]bool
+}
+
+func (f *failingClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if f.names[obj.GetName()] {
+		return errors.New("admission webhook denied the request")
+	}
+	return f.Client.Create(ctx, obj, opts...)
+}
+
+func (f *failingClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if f.names[obj.GetName()] {
+		return errors.New("admission webhook denied the request")
+	}
+	return f.Client.Update(ctx, obj, opts...)
+}
+
+func (f *failingClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	if f.names[obj.GetName()] {
+		return errors.New("admission webhook denied the request")
+	}
+	return f.Client.Delete(ctx, obj, opts...)
+}
@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// NODE_GROUPS are the groups of the mappings that let nodes join the cluster.
+var NODE_GROUPS = []string{"system:bootstrappers", "system:nodes"}
+
+/*
+LockoutProtection refuses changes of the ConfigMap that would lock the
+administrators or the nodes out of the cluster.
+*/
+type LockoutProtection struct {
+	// AdminGroups are the groups that grant administrative access, usually
+	// system:masters.
+	AdminGroups []string
+	// MinAdminMappings is the number of mappings with one of the AdminGroups
+	// that must remain. A write that reduces the number below it is refused.
+	MinAdminMappings int
+	// AllowNodeRemoval allows to remove mappings of the NODE_GROUPS.
+	AllowNodeRemoval bool
+}
+
+// BlockedError is returned if a write is refused by the LockoutProtection.
+type BlockedError struct {
+	// Reasons describe every violated rule.
+	Reasons []string
+}
+
+func (e *BlockedError) Error() string {
+	return "refused by lock-out protection: " + strings.Join(e.Reasons, "; ")
+}
+
+/*
+Check returns a BlockedError if the change from before to after removes too
+many admin mappings or any node mapping, see Enforce.
+*/
+func (p *LockoutProtection) Check(before, after *Mappings) error {
+	blocked := p.Enforce(before, after.Copy())
+	if len(blocked) == 0 {
+		return nil
+	}
+	reasons := []string{}
+	for _, r := range blocked {
+		reasons = append(reasons, r...)
+	}
+	sort.Strings(reasons)
+	return &BlockedError{Reasons: reasons}
+}
+
+/*
+Enforce reverts the entries of after that would lock out administrators or
+nodes to their state in before, including their owner and original entry, so
+that the rest of the change can still be written. It returns the violated
+rules by the key of the snippet that owns the reverted entry after the change,
+or before it if the entry was removed.
+
+The number of admin mappings may stay below the minimum if it does not
+decrease, so that clusters that never had enough of them are not blocked
+altogether. If it does decrease, all admin mappings that were removed or lost
+their admin groups are reverted. A node mapping counts as removed if its entry
+is removed or if it loses its node groups.
+*/
+func (p *LockoutProtection) Enforce(before, after *Mappings) map[string][]string {
+	if p == nil {
+		return nil
+	}
+	blocked := map[string][]string{}
+	block := func(arns []string, reason func(arn string) string) {
+		for _, arn := range arns {
+			owner, owned := after.Owners[arn]
+			if !owned {
+				owner = before.Owners[arn]
+			}
+			blocked[owner] = append(blocked[owner], reason(arn))
+			after.revert(before, arn)
+		}
+	}
+
+	adminsBefore := p.countAdminMappings(before)
+	adminsAfter := p.countAdminMappings(after)
+	if adminsAfter < p.MinAdminMappings && adminsAfter < adminsBefore {
+		groups := strings.Join(p.AdminGroups, " or ")
+		block(lostGroups(before, after, p.AdminGroups), func(arn string) string {
+			return fmt.Sprintf("%s is one of the last %d mappings with group %s", arn, p.MinAdminMappings, groups)
+		})
+	}
+	if !p.AllowNodeRemoval {
+		block(lostGroups(before, after, NODE_GROUPS), func(arn string) string {
+			return fmt.Sprintf("node mapping of %s would be removed", arn)
+		})
+	}
+
+	if len(blocked) == 0 {
+		return nil
+	}
+	return blocked
+}
+
+// lostGroups returns the ARNs of the role and user mappings that have one of
+// the groups in before but not in after, sorted.
+func lostGroups(before, after *Mappings, groups []string) []string {
+	arns := []string{}
+	for ra, mr := range before.Roles {
+		if hasGroup(mr.Groups, groups) && !hasGroup(after.Roles[ra].Groups, groups) {
+			arns = append(arns, ra)
+		}
+	}
+	for ua, mu := range before.Users {
+		if hasGroup(mu.Groups, groups) && !hasGroup(after.Users[ua].Groups, groups) {
+			arns = append(arns, ua)
+		}
+	}
+	sort.Strings(arns)
+	return arns
+}
+
+// countAdminMappings returns the number of role and user mappings with one of
+// the AdminGroups.
+func (p *LockoutProtection) countAdminMappings(mappings *Mappings) int {
+	count := 0
+	for _, mr := range mappings.Roles {
+		if hasGroup(mr.Groups, p.AdminGroups) {
+			count++
+		}
+	}
+	for _, mu := range mappings.Users {
+		if hasGroup(mu.Groups, p.AdminGroups) {
+			count++
+		}
+	}
+	return count
+}
+
+// hasGroup returns true if groups contains any of wanted.
+func hasGroup(groups, wanted []string) bool {
+	for _, group := range groups {
+		if containsString(wanted, group) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"context"
+
+	crdv1beta1 "github.com/inovex/aws-auth-controller/pkg/api/v1beta1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+var _ = Describe("lock-out protection", func() {
+	const (
+		ADMIN_ARN       = "arn:aws:iam::123456789012:role/admin"
+		OTHER_ADMIN_ARN = "arn:aws:iam::123456789012:user/admin"
+		NODE_ARN        = "arn:aws:iam::123456789012:role/node"
+	)
+
+	protection := &LockoutProtection{AdminGroups: []string{"system:masters"}, MinAdminMappings: 1}
+
+	mappings := func() *Mappings {
+		m := NewMappings()
+		m.Roles[ADMIN_ARN] = newRole(ADMIN_ARN, "admin", "system:masters")
+		m.Roles[NODE_ARN] = newRole(NODE_ARN, "system:node:{{EC2PrivateDNSName}}", "system:bootstrappers", "system:nodes")
+		return m
+	}
+
+	It("should allow changes that keep admins and nodes", func() {
+		after := mappings()
+		after.Users[OTHER_ADMIN_ARN] = newUser(OTHER_ADMIN_ARN, "admin", "system:masters")
+		Expect(protection.Check(mappings(), after)).To(Succeed())
+
+		before := after.Copy()
+		delete(after.Roles, ADMIN_ARN)
+		Expect(protection.Check(before, after)).To(Succeed())
+	})
+
+	It("should refuse to remove the last admin mapping", func() {
+		after := mappings()
+		delete(after.Roles, ADMIN_ARN)
+		err := protection.Check(mappings(), after)
+		Expect(err).To(BeAssignableToTypeOf(&BlockedError{}))
+		Expect(err.Error()).To(ContainSubstring("system:masters"))
+	})
+
+	It("should not block clusters that never had enough admin mappings", func() {
+		before := mappings()
+		delete(before.Roles, ADMIN_ARN)
+		after := before.Copy()
+		after.Users[OTHER_ADMIN_ARN] = newUser(OTHER_ADMIN_ARN, "dev", "dev")
+		Expect(protection.Check(before, after)).To(Succeed())
+	})
+
+	It("should refuse to remove node mappings unless allowed", func() {
+		after := mappings()
+		after.Roles[NODE_ARN] = newRole(NODE_ARN, "node", "dev")
+		err := protection.Check(mappings(), after)
+		Expect(err).To(BeAssignableToTypeOf(&BlockedError{}))
+		Expect(err.Error()).To(ContainSubstring(NODE_ARN))
+
+		delete(after.Roles, NODE_ARN)
+		Expect(protection.Check(mappings(), after)).ToNot(Succeed())
+
+		allowing := &LockoutProtection{AllowNodeRemoval: true}
+		Expect(allowing.Check(mappings(), after)).To(Succeed())
+	})
+
+	It("should keep the entries of a blocked snippet", func() {
+		current := NewMappings()
+		current.Roles[ADMIN_ARN] = newRole(ADMIN_ARN, "admin", "system:masters")
+		current.Owners[ADMIN_ARN] = "default/admin"
+		backend := &ConfigMapBackend{Client: newFakeClient(newConfigMap(current)), Protection: protection}
+
+		snip := deleted(newSnippet("admin", ""))
+		changes, err := backend.Apply(context.Background(), ComputeDesiredState([]Snippet{snip}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes.Removed).To(BeEmpty())
+		Expect(changes.Blocked).To(HaveKeyWithValue("default/admin", ConsistOf(ContainSubstring("system:masters"))))
+
+		mappings, err := backend.Load(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mappings.Roles).To(HaveKey(ADMIN_ARN))
+		Expect(mappings.Owners).To(HaveKeyWithValue(ADMIN_ARN, "default/admin"))
+	})
+
+	It("should apply unrelated snippets while another one is blocked", func() {
+		const TENANT_ARN = "arn:aws:iam::123456789012:role/tenant"
+		current := mappings()
+		current.Owners[ADMIN_ARN] = "default/admin"
+		// The admin snippet demotes the only admin mapping.
+		admin := newSnippet("admin", "admin", ADMIN_ARN)
+		tenant := newSnippet("tenant", "tenant", TENANT_ARN)
+		c := newFakeClient(newConfigMap(current), admin, tenant)
+		r := &AwsAuthMapSnippetReconciler{
+			Client:   c,
+			Recorder: record.NewFakeRecorder(10),
+			Backend:  &ConfigMapBackend{Client: c, Protection: protection},
+		}
+
+		Expect(r.syncConfigMap(context.Background(), tenant)).To(Succeed())
+		blocked := meta.FindStatusCondition(tenant.Status.Conditions, crdv1beta1.ConditionBlocked)
+		Expect(blocked.Status).To(Equal(metav1.ConditionFalse))
+
+		err := r.syncConfigMap(context.Background(), admin)
+		Expect(err).To(BeAssignableToTypeOf(&BlockedError{}))
+		blocked = meta.FindStatusCondition(admin.Status.Conditions, crdv1beta1.ConditionBlocked)
+		Expect(blocked.Status).To(Equal(metav1.ConditionTrue))
+		Expect(blocked.Message).To(ContainSubstring(ADMIN_ARN))
+
+		mappings, err := r.Backend.Load(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mappings.Roles).To(HaveKey(TENANT_ARN))
+		Expect(mappings.Roles[ADMIN_ARN].Groups).To(ConsistOf("system:masters"))
+		Expect(mappings.Roles).To(HaveKey(NODE_ARN))
+	})
+})
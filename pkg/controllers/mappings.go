@@ -0,0 +1,253 @@
+package controllers
+
+import (
+	"k8s.io/apimachinery/pkg/api/equality"
+
+	crdv1beta1 "github.com/inovex/aws-auth-controller/pkg/api/v1beta1"
+)
+
+// Mappings holds role, user and account mappings by ARN and account ID,
+// independent of where they are stored.
+type Mappings struct {
+	Roles    MapRolesByArn
+	Users    MapUsersByArn
+	Accounts MapAccountsByID
+
+	// Owners is the ownership index. It maps the ARNs and account IDs of
+	// managed entries to the key of the snippet that owns them. Entries
+	// without an owner were created by EKS or by hand.
+	Owners map[string]string
+	// Originals holds the unmanaged entries that snippets took over, by ARN
+	// and account ID. They are put back once no snippet declares them anymore.
+	Originals map[string]OriginalEntry
+}
+
+// OriginalEntry is an unmanaged entry that existed before a snippet took over
+// its ARN or account ID. Exactly one of the fields is set.
+type OriginalEntry struct {
+	Role    *crdv1beta1.MapRolesSpec `json:"role,omitempty"`
+	User    *crdv1beta1.MapUsersSpec `json:"user,omitempty"`
+	Account bool                     `json:"account,omitempty"`
+}
+
+// NewMappings returns empty Mappings.
+func NewMappings() *Mappings {
+	return &Mappings{
+		Roles:     MapRolesByArn{},
+		Users:     MapUsersByArn{},
+		Accounts:  MapAccountsByID{},
+		Owners:    map[string]string{},
+		Originals: map[string]OriginalEntry{},
+	}
+}
+
+// Copy returns a copy of the mappings that can be modified independently.
+func (m *Mappings) Copy() *Mappings {
+	c := NewMappings()
+	for ra, mr := range m.Roles {
+		c.Roles[ra] = mr
+	}
+	for ua, mu := range m.Users {
+		c.Users[ua] = mu
+	}
+	for aid := range m.Accounts {
+		c.Accounts[aid] = true
+	}
+	for arn, owner := range m.Owners {
+		c.Owners[arn] = owner
+	}
+	for arn, original := range m.Originals {
+		c.Originals[arn] = original
+	}
+	return c
+}
+
+/*
+Merge replaces all managed entries with the ones from the desired state and
+updates the ownership index.
+
+An entry is managed if it is listed as managed by the desired state or if the
+ownership index assigns it to one of the snippets of the desired state. Entries
+that were never managed by a snippet are kept as they are, and so are entries
+owned by snippets that are not part of the desired state.
+
+If a snippet takes over an unmanaged entry, the entry is remembered in
+Originals and put back once the ARN or account ID is not desired anymore.
+
+Protected entries are left untouched and dropped from the ownership index, so
+that they are unmanaged from then on.
+*/
+func (m *Mappings) Merge(desired *DesiredState) {
+	if m.Owners == nil {
+		m.Owners = map[string]string{}
+	}
+	if m.Originals == nil {
+		m.Originals = map[string]OriginalEntry{}
+	}
+	m.rememberOriginals(desired)
+	for arn := range m.Owners {
+		if desired.Protected.Matches(arn) {
+			delete(m.Owners, arn)
+		}
+	}
+
+	managed := func(arn string, managedArns map[string]bool) bool {
+		owner, indexed := m.Owners[arn]
+		return managedArns[arn] || indexed && desired.Snippets[owner]
+	}
+	removed := []string{}
+	for ra := range m.Roles {
+		if managed(ra, desired.ManagedRoleArns) {
+			delete(m.Roles, ra)
+			delete(m.Owners, ra)
+			removed = append(removed, ra)
+		}
+	}
+	for ua := range m.Users {
+		if managed(ua, desired.ManagedUserArns) {
+			delete(m.Users, ua)
+			delete(m.Owners, ua)
+			removed = append(removed, ua)
+		}
+	}
+	for aid := range m.Accounts {
+		if managed(aid, desired.ManagedAccounts) {
+			delete(m.Accounts, aid)
+			delete(m.Owners, aid)
+			removed = append(removed, aid)
+		}
+	}
+
+	for ra, mr := range desired.Roles {
+		m.Roles[ra] = mr
+		m.Owners[ra] = desired.Owners[ra]
+	}
+	for ua, mu := range desired.Users {
+		m.Users[ua] = mu
+		m.Owners[ua] = desired.Owners[ua]
+	}
+	for aid := range desired.Accounts {
+		m.Accounts[aid] = true
+		m.Owners[aid] = desired.Owners[aid]
+	}
+
+	for _, arn := range removed {
+		if _, owned := m.Owners[arn]; !owned {
+			m.restoreOriginal(arn)
+		}
+	}
+}
+
+/*
+rememberOriginals stores the unmanaged entries whose ARN or account ID is
+about to be taken over by a snippet of the desired state.
+
+Entries that are listed in the status of a snippet were written by the
+controller before the ownership index existed, they are not unmanaged. Entries
+that are adopted by a snippet with the ADOPT_ANNOTATION, i.e. that equal its
+declaration, are not remembered either. They belong to the snippet from then
+on and are removed with it.
+*/
+func (m *Mappings) rememberOriginals(desired *DesiredState) {
+	unmanaged := func(arn string) bool {
+		_, owned := m.Owners[arn]
+		_, remembered := m.Originals[arn]
+		return !owned && !remembered && !desired.Applied[arn]
+	}
+	adopting := func(arn string) bool {
+		return desired.Adopting[desired.Owners[arn]]
+	}
+	for ra, desiredRole := range desired.Roles {
+		mr, ok := m.Roles[ra]
+		if ok && unmanaged(ra) && !(adopting(ra) && equality.Semantic.DeepEqual(mr, desiredRole)) {
+			m.Originals[ra] = OriginalEntry{Role: &mr}
+		}
+	}
+	for ua, desiredUser := range desired.Users {
+		mu, ok := m.Users[ua]
+		if ok && unmanaged(ua) && !(adopting(ua) && equality.Semantic.DeepEqual(mu, desiredUser)) {
+			m.Originals[ua] = OriginalEntry{User: &mu}
+		}
+	}
+	for aid := range desired.Accounts {
+		if m.Accounts[aid] && unmanaged(aid) && !adopting(aid) {
+			m.Originals[aid] = OriginalEntry{Account: true}
+		}
+	}
+}
+
+// Remove deletes the managed entry of the ARN or account ID. The original
+// entry is put back if a snippet took it over.
+func (m *Mappings) Remove(arn string) {
+	delete(m.Roles, arn)
+	delete(m.Users, arn)
+	delete(m.Accounts, arn)
+	delete(m.Owners, arn)
+	m.restoreOriginal(arn)
+}
+
+/*
+index returns the ownership index and the original entries to be stored along
+with the mappings. Owners of entries that do not exist are left out, and so
+are the originals of entries that are not managed anymore.
+*/
+func (m *Mappings) index() (map[string]string, map[string]OriginalEntry) {
+	owners := map[string]string{}
+	for arn, owner := range m.Owners {
+		_, role := m.Roles[arn]
+		_, user := m.Users[arn]
+		if role || user || m.Accounts[arn] {
+			owners[arn] = owner
+		}
+	}
+	originals := map[string]OriginalEntry{}
+	for arn, original := range m.Originals {
+		if _, owned := owners[arn]; owned {
+			originals[arn] = original
+		}
+	}
+	return owners, originals
+}
+
+// revert sets the entry of the ARN or account ID back to the one of before,
+// together with its owner and original entry.
+func (m *Mappings) revert(before *Mappings, arn string) {
+	delete(m.Roles, arn)
+	delete(m.Users, arn)
+	delete(m.Accounts, arn)
+	delete(m.Owners, arn)
+	delete(m.Originals, arn)
+	if mr, ok := before.Roles[arn]; ok {
+		m.Roles[arn] = mr
+	}
+	if mu, ok := before.Users[arn]; ok {
+		m.Users[arn] = mu
+	}
+	if before.Accounts[arn] {
+		m.Accounts[arn] = true
+	}
+	if owner, ok := before.Owners[arn]; ok {
+		m.Owners[arn] = owner
+	}
+	if original, ok := before.Originals[arn]; ok {
+		m.Originals[arn] = original
+	}
+}
+
+// restoreOriginal puts back the original entry of the ARN or account ID, if
+// there is one. It is unmanaged again afterwards.
+func (m *Mappings) restoreOriginal(arn string) {
+	original, ok := m.Originals[arn]
+	if !ok {
+		return
+	}
+	switch {
+	case original.Role != nil:
+		m.Roles[arn] = *original.Role
+	case original.User != nil:
+		m.Users[arn] = *original.User
+	case original.Account:
+		m.Accounts[arn] = true
+	}
+	delete(m.Originals, arn)
+}
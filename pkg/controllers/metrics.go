@@ -0,0 +1,218 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	crdv1beta1 "github.com/inovex/aws-auth-controller/pkg/api/v1beta1"
+)
+
+var (
+	configMapWriteAttempts = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "awsauth_configmap_write_attempts_total",
+		Help: "Number of attempted writes to the aws-auth ConfigMap.",
+	})
+	configMapWriteConflicts = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "awsauth_configmap_write_conflicts_total",
+		Help: "Number of writes to the aws-auth ConfigMap that were retried because of a concurrent modification.",
+	})
+	configMapWriteFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "awsauth_configmap_write_failures_total",
+		Help: "Number of updates of the aws-auth ConfigMap that failed, including all retries.",
+	})
+	driftCorrections = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "awsauth_drift_corrections_total",
+		Help: "Number of managed entries that were missing from or modified in the aws-auth ConfigMap and got restored.",
+	})
+	backendDivergence = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "awsauth_backend_divergent_mappings",
+		Help: "Number of managed mappings that differ between the primary backend and the given backend in dual-write mode.",
+	}, []string{"backend"})
+	orphanedMappings = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "awsauth_orphaned_mappings",
+		Help: "Number of managed entries of the aws-auth ConfigMap whose snippet does not exist anymore, as found by the last sweep.",
+	})
+	orphansRemoved = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "awsauth_orphans_removed_total",
+		Help: "Number of orphaned entries that were removed from the aws-auth ConfigMap.",
+	})
+	configMapWritesBlocked = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "awsauth_configmap_writes_blocked_total",
+		Help: "Number of writes to the aws-auth ConfigMap that were refused or reduced by the lock-out protection.",
+	})
+)
+
+var (
+	mappingsDesc = prometheus.NewDesc(
+		"awsauth_mappings",
+		"Number of mappings in the backend by type and whether they are managed by a snippet.",
+		[]string{"type", "managed"}, nil,
+	)
+	configMapBytesDesc = prometheus.NewDesc(
+		"awsauth_configmap_bytes",
+		"Size of the data of the aws-auth ConfigMap in bytes.",
+		nil, nil,
+	)
+	snippetsDesc = prometheus.NewDesc(
+		"awsauth_snippets",
+		"Number of snippets by kind and sync state, which is the reason of the Ready condition.",
+		[]string{"kind", "state"}, nil,
+	)
+	mappingInfoDesc = prometheus.NewDesc(
+		"awsauth_mapping_info",
+		"Information about every mapping in the backend. Namespace and snippet are empty for unmanaged mappings.",
+		[]string{"type", "arn", "username", "namespace", "snippet"}, nil,
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		configMapWriteAttempts,
+		configMapWriteConflicts,
+		configMapWriteFailures,
+		driftCorrections,
+		backendDivergence,
+		orphanedMappings,
+		orphansRemoved,
+		configMapWritesBlocked,
+	)
+}
+
+/*
+stateCollector reports the current content of the backend and the state of
+all snippets.
+
+The snippets are listed on every scrape from the cache of the reconciler's
+client. The mappings are the ones the reconciler wrote last, so a scrape never
+calls the backend, e.g. the EKS API. Nothing is reported about them until the
+first write.
+*/
+type stateCollector struct {
+	r *AwsAuthMapSnippetReconciler
+}
+
+var _ prometheus.Collector = &stateCollector{}
+
+// Describe implements prometheus.Collector.
+func (c *stateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- mappingsDesc
+	ch <- configMapBytesDesc
+	ch <- snippetsDesc
+	ch <- mappingInfoDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *stateCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx := context.Background()
+	snippets, err := c.r.listSnippets(ctx, nil)
+	if err != nil {
+		log.Log.Error(err, "Failed to list snippets for metrics")
+		return
+	}
+
+	states := map[[2]string]int{}
+	for _, snippet := range snippets {
+		kind := "AwsAuthMapSnippet"
+		if snippet.GetNamespace() == "" {
+			kind = "ClusterAwsAuthMapSnippet"
+		}
+		states[[2]string{kind, snippetState(snippet)}]++
+	}
+	for labels, count := range states {
+		ch <- prometheus.MustNewConstMetric(snippetsDesc, prometheus.GaugeValue, float64(count), labels[0], labels[1])
+	}
+
+	current := c.r.appliedMappings()
+	if current == nil {
+		return
+	}
+
+	if cmb := c.r.configMapBackend(); cmb != nil {
+		cm := &corev1.ConfigMap{}
+		err := cmb.Get(ctx, cmb.Key(), cm)
+		if client.IgnoreNotFound(err) != nil {
+			log.Log.Error(err, "Failed to get ConfigMap for metrics")
+		} else if err == nil {
+			size := 0
+			for key, value := range cm.Data {
+				size += len(key) + len(value)
+			}
+			ch <- prometheus.MustNewConstMetric(configMapBytesDesc, prometheus.GaugeValue, float64(size))
+		}
+	}
+
+	desired := c.r.desiredState(snippets)
+	counts := map[[2]string]int{
+		{"role", "true"}: 0, {"role", "false"}: 0,
+		{"user", "true"}: 0, {"user", "false"}: 0,
+		{"account", "true"}: 0, {"account", "false"}: 0,
+	}
+	mapping := func(kind, arn, username string) {
+		owner, managed := desired.Owners[arn]
+		if !managed {
+			// Entries of snippets that are not handled by this controller,
+			// e.g. of another controller class.
+			owner, managed = current.Owners[arn]
+		}
+		if managed {
+			counts[[2]string{kind, "true"}]++
+		} else {
+			counts[[2]string{kind, "false"}]++
+		}
+		namespace, name := splitSnippetKey(owner)
+		ch <- prometheus.MustNewConstMetric(mappingInfoDesc, prometheus.GaugeValue, 1, kind, arn, username, namespace, name)
+	}
+	for ra, mr := range current.Roles {
+		mapping("role", ra, mr.UserName)
+	}
+	for ua, mu := range current.Users {
+		mapping("user", ua, mu.UserName)
+	}
+	for aid := range current.Accounts {
+		mapping("account", aid, "")
+	}
+	for labels, count := range counts {
+		ch <- prometheus.MustNewConstMetric(mappingsDesc, prometheus.GaugeValue, float64(count), labels[0], labels[1])
+	}
+}
+
+// rememberMappings caches a copy of the mappings of the primary backend for
+// the metrics.
+func (r *AwsAuthMapSnippetReconciler) rememberMappings(mappings *Mappings) {
+	mappings = mappings.Copy()
+	r.appliedMutex.Lock()
+	defer r.appliedMutex.Unlock()
+	r.applied = mappings
+}
+
+// appliedMappings returns the mappings cached by rememberMappings, or nil.
+func (r *AwsAuthMapSnippetReconciler) appliedMappings() *Mappings {
+	r.appliedMutex.Lock()
+	defer r.appliedMutex.Unlock()
+	return r.applied
+}
+
+// snippetState returns the reason of the Ready condition of the snippet, or
+// Pending if it was not reconciled yet.
+func snippetState(snippet Snippet) string {
+	ready := meta.FindStatusCondition(snippet.GetStatus().Conditions, crdv1beta1.ConditionReady)
+	if ready == nil {
+		return "Pending"
+	}
+	return ready.Reason
+}
+
+// splitSnippetKey is the inverse of snippetKey.
+func splitSnippetKey(key string) (namespace, name string) {
+	if i := strings.Index(key, "/"); i >= 0 {
+		return key[:i], key[i+1:]
+	}
+	return "", key
+}
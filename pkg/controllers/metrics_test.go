@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/client-go/tools/record"
+)
+
+// countingBackend counts the calls of Load.
+type countingBackend struct {
+	Backend
+	loads int
+}
+
+func (b *countingBackend) Load(ctx context.Context) (*Mappings, error) {
+	b.loads++
+	return b.Backend.Load(ctx)
+}
+
+var _ = Describe("metrics", func() {
+	const ROLE_ARN = "arn:aws:iam::123456789012:role/metrics"
+
+	It("should report the mappings of the last write without loading them", func() {
+		snip := newSnippet("metrics", "metrics", ROLE_ARN)
+		backend := &countingBackend{Backend: NewInMemoryBackend()}
+		r := &AwsAuthMapSnippetReconciler{
+			Client:   newFakeClient(snip),
+			Recorder: record.NewFakeRecorder(10),
+			Backend:  backend,
+		}
+		collector := &stateCollector{r}
+		Expect(testutil.CollectAndCount(collector, "awsauth_mapping_info")).To(Equal(0))
+
+		Expect(r.syncConfigMap(context.Background(), snip)).To(Succeed())
+		Expect(testutil.CollectAndCount(collector, "awsauth_mapping_info")).To(Equal(1))
+		Expect(testutil.CollectAndCount(collector, "awsauth_mappings")).To(Equal(6))
+		Expect(backend.loads).To(BeZero())
+	})
+
+	It("should count drift corrections once they are written", func() {
+		snip := newSnippet("metrics", "metrics", ROLE_ARN)
+		drifted := NewMappings()
+		drifted.Roles[ROLE_ARN] = newRole(ROLE_ARN, "modified", "foobar-group")
+		drifted.Owners[ROLE_ARN] = "default/metrics"
+		cm := newConfigMap(drifted)
+		c := newFakeClient(snip, cm)
+		r := &AwsAuthMapSnippetReconciler{
+			Client:   c,
+			Recorder: record.NewFakeRecorder(10),
+			Backend:  &ConfigMapBackend{Client: c},
+		}
+		corrections := testutil.ToFloat64(driftCorrections)
+
+		Expect(r.findDriftedSnippets(false)(cm)).To(HaveLen(1))
+		Expect(testutil.ToFloat64(driftCorrections)).To(Equal(corrections))
+
+		Expect(r.syncConfigMap(context.Background(), snip)).To(Succeed())
+		Expect(testutil.ToFloat64(driftCorrections)).To(Equal(corrections + 1))
+		// Writes without drift are not counted.
+		Expect(r.syncConfigMap(context.Background(), snip)).To(Succeed())
+		Expect(testutil.ToFloat64(driftCorrections)).To(Equal(corrections + 1))
+	})
+})
@@ -0,0 +1,190 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	crdv1beta1 "github.com/inovex/aws-auth-controller/pkg/api/v1beta1"
+)
+
+// Policies of the orphan sweeper.
+const (
+	// ORPHAN_POLICY_DELETE removes orphaned entries from the ConfigMap.
+	ORPHAN_POLICY_DELETE = "delete"
+	// ORPHAN_POLICY_REPORT only logs orphaned entries and records an event.
+	ORPHAN_POLICY_REPORT = "report"
+)
+
+// Reasons of the events that are recorded on the ConfigMap.
+const (
+	EventReasonOrphansFound   = "OrphansFound"
+	EventReasonOrphansRemoved = "OrphansRemoved"
+)
+
+/*
+SweepOrphans looks for orphaned entries at startup and then every
+OrphanSweepPeriod. Errors are only logged, the next sweep will try again.
+*/
+func (r *AwsAuthMapSnippetReconciler) SweepOrphans(ctx context.Context) error {
+	r.sweepOnce(ctx)
+	if r.Options.OrphanSweepPeriod <= 0 {
+		return nil
+	}
+
+	ticker := time.NewTicker(r.Options.OrphanSweepPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			r.sweepOnce(ctx)
+		}
+	}
+}
+
+func (r *AwsAuthMapSnippetReconciler) sweepOnce(ctx context.Context) {
+	logger := log.FromContext(ctx)
+	if restore, err := r.pausedBy(ctx); err != nil || restore != "" {
+		logger.Info("Skipping orphan sweep, reconciliation is paused", "restore", restore, "error", err)
+		return
+	}
+	if err := r.sweepOrphans(ctx); err != nil {
+		logger.Error(err, "Orphan sweep failed")
+	}
+}
+
+/*
+sweepOrphans finds the entries of the ConfigMap whose owner in the ownership
+index does not exist anymore, e.g. because its finalizer was removed by force
+or the CRD was deleted. They are removed if the orphan policy is delete and
+the controller is not in dry-run mode, otherwise they are only reported.
+
+Snippets of all namespaces and controller classes count as owners, so that
+entries of other controllers sharing the ConfigMap are never swept. Protected
+entries are never swept either.
+*/
+func (r *AwsAuthMapSnippetReconciler) sweepOrphans(ctx context.Context) error {
+	logger := log.FromContext(ctx)
+	cmb := r.configMapBackend()
+	if cmb == nil {
+		return nil
+	}
+	owners, err := r.existingSnippetKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctx = WithTrigger(ctx, "orphan-sweeper")
+	awsauth := cmb.awsAuthMap()
+	remove := r.Options.OrphanPolicy == ORPHAN_POLICY_DELETE && !r.Options.DryRun
+	orphans := map[string]string{}
+	// After a conflict the cache may still hold the conflicting version.
+	read := awsauth.Read
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := read(ctx); err != nil {
+			return err
+		}
+		read = awsauth.reread
+		orphans = findOrphans(awsauth.Mappings(), owners)
+		for arn := range orphans {
+			if r.Options.ProtectedArns.Matches(arn) {
+				delete(orphans, arn)
+			}
+		}
+		if !remove || len(orphans) == 0 {
+			return nil
+		}
+		mappings := awsauth.Mappings()
+		for arn := range orphans {
+			mappings.Remove(arn)
+		}
+		return awsauth.Write(ctx)
+	})
+	if err != nil {
+		return err
+	}
+	if remove && len(orphans) > 0 && r.primaryBackend() == Backend(cmb) {
+		r.rememberMappings(awsauth.Mappings())
+	}
+
+	arns := []string{}
+	for arn, owner := range orphans {
+		arns = append(arns, fmt.Sprintf("%s (snippet %s)", arn, owner))
+	}
+	sort.Strings(arns)
+	if len(orphans) == 0 {
+		orphanedMappings.Set(0)
+		return nil
+	}
+	if remove {
+		orphanedMappings.Set(0)
+		orphansRemoved.Add(float64(len(orphans)))
+		logger.Info("Removed orphaned mappings", "orphans", arns)
+		r.Recorder.Event(awsauth.ConfigMap, corev1.EventTypeNormal, EventReasonOrphansRemoved,
+			fmt.Sprintf("Removed orphaned mappings for %s", strings.Join(arns, ", ")))
+		return nil
+	}
+	orphanedMappings.Set(float64(len(orphans)))
+	logger.Info("Found orphaned mappings", "orphans", arns, "policy", r.Options.OrphanPolicy)
+	r.Recorder.Event(awsauth.ConfigMap, corev1.EventTypeWarning, EventReasonOrphansFound,
+		fmt.Sprintf("Found orphaned mappings for %s", strings.Join(arns, ", ")))
+	return nil
+}
+
+// existingSnippetKeys returns the keys of all snippets of both kinds,
+// regardless of namespace and controller class.
+func (r *AwsAuthMapSnippetReconciler) existingSnippetKeys(ctx context.Context) (map[string]bool, error) {
+	snippets, err := r.allSnippets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys := map[string]bool{}
+	for _, snippet := range snippets {
+		keys[snippetKey(snippet)] = true
+	}
+	return keys, nil
+}
+
+// allSnippets returns all snippets of both kinds, regardless of namespace and
+// controller class.
+func (r *AwsAuthMapSnippetReconciler) allSnippets(ctx context.Context) ([]Snippet, error) {
+	snippetList := &crdv1beta1.AwsAuthMapSnippetList{}
+	if err := r.List(ctx, snippetList); err != nil {
+		return nil, err
+	}
+	clusterSnippetList := &crdv1beta1.ClusterAwsAuthMapSnippetList{}
+	if err := r.List(ctx, clusterSnippetList); err != nil {
+		return nil, err
+	}
+
+	snippets := []Snippet{}
+	for i := range snippetList.Items {
+		snippets = append(snippets, &snippetList.Items[i])
+	}
+	for i := range clusterSnippetList.Items {
+		snippets = append(snippets, &clusterSnippetList.Items[i])
+	}
+	return snippets, nil
+}
+
+// findOrphans returns the owners of all indexed entries whose owner is not
+// among the given snippet keys, by ARN or account ID.
+func findOrphans(current *Mappings, snippets map[string]bool) map[string]string {
+	orphans := map[string]string{}
+	for arn, owner := range current.Owners {
+		_, role := current.Roles[arn]
+		_, user := current.Users[arn]
+		if (role || user || current.Accounts[arn]) && !snippets[owner] {
+			orphans[arn] = owner
+		}
+	}
+	return orphans
+}
@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("orphan sweeper", func() {
+	const (
+		LIVING_ARN   = "arn:aws:iam::123456789012:role/living"
+		ORPHANED_ARN = "arn:aws:iam::123456789012:role/orphaned"
+		NODE_ARN     = "arn:aws:iam::123456789012:role/node"
+	)
+
+	var (
+		c        client.Client
+		recorder *record.FakeRecorder
+		r        *AwsAuthMapSnippetReconciler
+	)
+
+	BeforeEach(func() {
+		current := NewMappings()
+		current.Roles[LIVING_ARN] = newRole(LIVING_ARN, "living", "living")
+		current.Roles[NODE_ARN] = newRole(NODE_ARN, "node", "system:nodes")
+		current.Roles[ORPHANED_ARN] = newRole(ORPHANED_ARN, "orphaned", "orphaned")
+		current.Owners[LIVING_ARN] = "default/living"
+		current.Owners[ORPHANED_ARN] = "default/gone"
+		cm := newConfigMap(current)
+		cm.Annotations[MANAGED_ANNOTATION] = "true"
+		c = newFakeClient(cm, newSnippet("living", "living"))
+		recorder = record.NewFakeRecorder(10)
+		r = &AwsAuthMapSnippetReconciler{
+			Client:   c,
+			Recorder: recorder,
+			Backend:  &ConfigMapBackend{Client: c},
+		}
+	})
+
+	loadRoles := func() MapRolesByArn {
+		mappings, err := r.Backend.Load(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		return mappings.Roles
+	}
+
+	It("should only report orphans by default", func() {
+		Expect(r.sweepOrphans(context.Background())).To(Succeed())
+		Expect(loadRoles()).To(HaveKey(ORPHANED_ARN))
+		Expect(recorder.Events).To(Receive(And(
+			ContainSubstring(EventReasonOrphansFound),
+			ContainSubstring(ORPHANED_ARN),
+			ContainSubstring("default/gone"),
+		)))
+	})
+
+	It("should remove orphans with the delete policy", func() {
+		r.Options.OrphanPolicy = ORPHAN_POLICY_DELETE
+		Expect(r.sweepOrphans(context.Background())).To(Succeed())
+
+		roles := loadRoles()
+		Expect(roles).ToNot(HaveKey(ORPHANED_ARN))
+		Expect(roles).To(HaveKey(LIVING_ARN))
+		Expect(roles).To(HaveKey(NODE_ARN))
+		Expect(recorder.Events).To(Receive(ContainSubstring(EventReasonOrphansRemoved)))
+	})
+
+	It("should restore the original entry of removed orphans", func() {
+		cm := &corev1.ConfigMap{}
+		Expect(c.Get(context.Background(), DefaultConfigMapKey, cm)).To(Succeed())
+		original := newRole(ORPHANED_ARN, "original", "original")
+		originals, err := json.Marshal(map[string]OriginalEntry{ORPHANED_ARN: {Role: &original}})
+		Expect(err).ToNot(HaveOccurred())
+		cm.Annotations[ORIGINALS_ANNOTATION] = string(originals)
+		Expect(c.Update(context.Background(), cm)).To(Succeed())
+
+		r.Options.OrphanPolicy = ORPHAN_POLICY_DELETE
+		Expect(r.sweepOrphans(context.Background())).To(Succeed())
+		Expect(loadRoles()[ORPHANED_ARN].UserName).To(Equal("original"))
+	})
+
+	It("should not remove orphans in dry-run mode", func() {
+		r.Options.OrphanPolicy = ORPHAN_POLICY_DELETE
+		r.Options.DryRun = true
+		Expect(r.sweepOrphans(context.Background())).To(Succeed())
+		Expect(loadRoles()).To(HaveKey(ORPHANED_ARN))
+	})
+})
@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"fmt"
+	"path"
+)
+
+/*
+ProtectedArns lists the ARNs and account IDs of entries that the controller
+never modifies or removes, e.g. break-glass roles or node roles created by
+Terraform. Every item is either an exact ARN or a glob pattern as understood
+by path.Match, so `*` does not match the slashes of an IAM path.
+*/
+type ProtectedArns []string
+
+// Validate returns an error if one of the patterns is malformed.
+func (p ProtectedArns) Validate() error {
+	for _, pattern := range p {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("protected ARN %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Matches returns true if the ARN or account ID is protected.
+func (p ProtectedArns) Matches(arn string) bool {
+	for _, pattern := range p {
+		if pattern == arn {
+			return true
+		}
+		if matched, _ := path.Match(pattern, arn); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Declared returns the protected ARNs and account IDs that are declared by
+// the spec of the snippet.
+func (p ProtectedArns) Declared(snippet Snippet) []string {
+	declared := []string{}
+	spec := snippet.GetSpec()
+	for _, mr := range spec.MapRoles {
+		if p.Matches(mr.RoleArn) {
+			declared = append(declared, mr.RoleArn)
+		}
+	}
+	for _, mu := range spec.MapUsers {
+		if p.Matches(mu.UserArn) {
+			declared = append(declared, mu.UserArn)
+		}
+	}
+	for _, ma := range spec.MapAccounts {
+		if p.Matches(string(ma)) {
+			declared = append(declared, string(ma))
+		}
+	}
+	return declared
+}
+
+/*
+keepProtected makes the protected entries of updated equal to the ones of
+current, so that e.g. a restore neither modifies nor removes them. Protected
+entries are never owned by a snippet.
+*/
+func (p ProtectedArns) keepProtected(current, updated *Mappings) {
+	for arn := range updated.Roles {
+		if p.Matches(arn) {
+			delete(updated.Roles, arn)
+		}
+	}
+	for arn := range updated.Users {
+		if p.Matches(arn) {
+			delete(updated.Users, arn)
+		}
+	}
+	for arn := range updated.Owners {
+		if p.Matches(arn) {
+			delete(updated.Owners, arn)
+			delete(updated.Originals, arn)
+		}
+	}
+	for arn, mr := range current.Roles {
+		if p.Matches(arn) {
+			updated.Roles[arn] = mr
+		}
+	}
+	for arn, mu := range current.Users {
+		if p.Matches(arn) {
+			updated.Users[arn] = mu
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"context"
+
+	crdv1beta1 "github.com/inovex/aws-auth-controller/pkg/api/v1beta1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("protected ARNs", func() {
+	const (
+		BREAK_GLASS_ARN = "arn:aws:iam::123456789012:role/break-glass-admin"
+		NODE_ARN        = "arn:aws:iam::123456789012:role/terraform/node"
+		TENANT_ARN      = "arn:aws:iam::123456789012:role/tenant"
+	)
+
+	protected := ProtectedArns{"arn:aws:iam::123456789012:role/break-glass-*", NODE_ARN}
+
+	It("should match exact ARNs and glob patterns", func() {
+		Expect(protected.Matches(BREAK_GLASS_ARN)).To(BeTrue())
+		Expect(protected.Matches(NODE_ARN)).To(BeTrue())
+		Expect(protected.Matches(TENANT_ARN)).To(BeFalse())
+		Expect(protected.Matches("arn:aws:iam::123456789012:role/break-glass-/nested")).To(BeFalse())
+		Expect(ProtectedArns(nil).Matches(TENANT_ARN)).To(BeFalse())
+	})
+
+	It("should reject malformed patterns", func() {
+		Expect(protected.Validate()).To(Succeed())
+		Expect(ProtectedArns{"arn:aws:iam::123456789012:role/["}.Validate()).ToNot(Succeed())
+	})
+
+	It("should neither overwrite nor delete protected entries", func() {
+		backend := NewInMemoryBackend()
+		current := NewMappings()
+		current.Roles[BREAK_GLASS_ARN] = newRole(BREAK_GLASS_ARN, "break-glass", "system:masters")
+		current.Roles[NODE_ARN] = newRole(NODE_ARN, "node", "system:nodes")
+		// The node role was taken over before it was protected.
+		current.Owners[NODE_ARN] = "default/tenant"
+		backend.Store(current)
+
+		snip := newSnippet("tenant", "tenant", BREAK_GLASS_ARN, TENANT_ARN)
+		desired := ComputeDesiredState([]Snippet{snip})
+		desired.Protect(protected)
+		_, err := backend.Apply(context.Background(), desired)
+		Expect(err).ToNot(HaveOccurred())
+
+		mappings, err := backend.Load(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mappings.Roles[BREAK_GLASS_ARN].UserName).To(Equal("break-glass"))
+		Expect(mappings.Roles[NODE_ARN].UserName).To(Equal("node"))
+		Expect(mappings.Roles).To(HaveKey(TENANT_ARN))
+		Expect(mappings.Owners).To(Equal(map[string]string{TENANT_ARN: "default/tenant"}))
+
+		setRejectedCondition(snip, protected.Declared(snip), nil)
+		rejected := meta.FindStatusCondition(snip.Status.Conditions, crdv1beta1.ConditionRejected)
+		Expect(rejected.Status).To(Equal(metav1.ConditionTrue))
+		Expect(rejected.Message).To(ContainSubstring(BREAK_GLASS_ARN))
+	})
+})
@@ -0,0 +1,46 @@
+package controllers
+
+import (
+	crdv1beta1 "github.com/inovex/aws-auth-controller/pkg/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+/*
+Snippet is implemented by AwsAuthMapSnippet and ClusterAwsAuthMapSnippet so
+that both kinds can be handled by the same logic.
+*/
+type Snippet interface {
+	client.Object
+	GetSpec() *crdv1beta1.AwsAuthMapSnippetSpec
+	GetStatus() *crdv1beta1.AwsAuthMapSnippetStatus
+}
+
+/*
+snippetPrecedes orders snippets by precedence. Cluster-scoped snippets come
+first, then snippets are ordered by creation time, namespace and name.
+*/
+func snippetPrecedes(a, b Snippet) bool {
+	if a.GetNamespace() != b.GetNamespace() && (a.GetNamespace() == "" || b.GetNamespace() == "") {
+		return a.GetNamespace() == ""
+	}
+	ta, tb := a.GetCreationTimestamp(), b.GetCreationTimestamp()
+	if !ta.Equal(&tb) {
+		return ta.Before(&tb)
+	}
+	if a.GetNamespace() != b.GetNamespace() {
+		return a.GetNamespace() < b.GetNamespace()
+	}
+	return a.GetName() < b.GetName()
+}
+
+/*
+snippetKey returns the key by which a snippet is referred to. This is
+namespace/name for namespaced snippets and just the name for cluster-scoped
+ones.
+*/
+func snippetKey(s client.Object) string {
+	if s.GetNamespace() == "" {
+		return s.GetName()
+	}
+	return s.GetNamespace() + "/" + s.GetName()
+}
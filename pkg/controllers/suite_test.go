@@ -19,7 +19,9 @@ package controllers
 import (
 	"context"
 	"errors"
+	"os"
 	"path/filepath"
+	"strconv"
 	"testing"
 
 	. "github.com/onsi/ginkgo/v2"
@@ -44,6 +46,7 @@ var (
 	cfg       *rest.Config
 	k8sClient *FakeApiClient
 	testEnv   *envtest.Environment
+	history   *History
 	ctx       context.Context
 	cancel    context.CancelFunc
 )
@@ -54,23 +57,69 @@ func TestAPIs(t *testing.T) {
 	RunSpecs(t, "Controller Suite")
 }
 
+/*
+requireEnvtest skips the spec unless the control plane of envtest is running.
+It is only started if KUBEBUILDER_ASSETS points to its binaries, as `make
+test` does, so that the other specs also run without them.
+*/
+func requireEnvtest() {
+	if testEnv == nil {
+		Skip("needs envtest, run make test or set KUBEBUILDER_ASSETS")
+	}
+}
+
 type FakeApiClient struct {
 	client.Client
 
 	FailUpdateName string
+
+	// ConcurrentUpdateName causes the next ConcurrentUpdates updates of the
+	// named object to be preceded by a concurrent update of the object, so
+	// that the API server rejects them with a 409 response.
+	ConcurrentUpdateName string
+	ConcurrentUpdates    int
 }
 
+// CONCURRENT_ANNOTATION counts the concurrent updates of FakeApiClient.
+const CONCURRENT_ANNOTATION = "test.awsauth.io/concurrent-updates"
+
 func (f *FakeApiClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
 	if obj.GetName() == f.FailUpdateName {
 		return errors.New("Conflict")
 	}
+	if obj.GetName() == f.ConcurrentUpdateName && f.ConcurrentUpdates > 0 {
+		f.ConcurrentUpdates--
+		if err := f.updateConcurrently(ctx, obj); err != nil {
+			return err
+		}
+	}
 
 	return f.Client.Update(ctx, obj, opts...)
 }
 
+// updateConcurrently updates a fresh copy of the object, which makes the
+// resource version of obj stale.
+func (f *FakeApiClient) updateConcurrently(ctx context.Context, obj client.Object) error {
+	fresh := obj.DeepCopyObject().(client.Object)
+	if err := f.Client.Get(ctx, client.ObjectKeyFromObject(obj), fresh); err != nil {
+		return err
+	}
+	annotations := fresh.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	count, _ := strconv.Atoi(annotations[CONCURRENT_ANNOTATION])
+	annotations[CONCURRENT_ANNOTATION] = strconv.Itoa(count + 1)
+	fresh.SetAnnotations(annotations)
+	return f.Client.Update(ctx, fresh)
+}
+
 var _ = BeforeSuite(func() {
 	logf.SetLogger(zap.New(zap.WriteTo(GinkgoWriter), zap.UseDevMode(true)))
 	ctx, cancel = context.WithCancel(context.TODO())
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		return
+	}
 
 	By("bootstrapping test environment")
 	testEnv = &envtest.Environment{
@@ -102,9 +151,28 @@ var _ = BeforeSuite(func() {
 	})
 	Expect(err).ToNot(HaveOccurred())
 
-	err = (&AwsAuthMapSnippetReconciler{
-		Client: k8sClient,
-		Scheme: k8sManager.GetScheme(),
+	history = &History{
+		Client:    k8sClient,
+		Reader:    k8sManager.GetAPIReader(),
+		Namespace: "default",
+		Size:      10,
+	}
+	snippetReconciler := &AwsAuthMapSnippetReconciler{
+		Client:   k8sClient,
+		Scheme:   k8sManager.GetScheme(),
+		Recorder: k8sManager.GetEventRecorderFor("aws-auth-controller"),
+		Backend:  &ConfigMapBackend{Client: k8sClient, Reader: k8sManager.GetAPIReader(), History: history},
+	}
+	err = snippetReconciler.SetupWithManager(k8sManager)
+	Expect(err).ToNot(HaveOccurred())
+
+	err = (&ClusterAwsAuthMapSnippetReconciler{
+		AwsAuthMapSnippetReconciler: snippetReconciler,
+	}).SetupWithManager(k8sManager)
+	Expect(err).ToNot(HaveOccurred())
+
+	err = (&AwsAuthMapRestoreReconciler{
+		AwsAuthMapSnippetReconciler: snippetReconciler,
 	}).SetupWithManager(k8sManager)
 	Expect(err).ToNot(HaveOccurred())
 
@@ -118,6 +186,9 @@ var _ = BeforeSuite(func() {
 
 var _ = AfterSuite(func() {
 	cancel()
+	if testEnv == nil {
+		return
+	}
 	By("tearing down the test environment")
 	err := testEnv.Stop()
 	Expect(err).NotTo(HaveOccurred())
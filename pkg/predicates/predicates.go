@@ -1,22 +1,53 @@
 package predicates
 
 import (
+	crdv1beta1 "github.com/inovex/aws-auth-controller/pkg/api/v1beta1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 )
 
 func NamespaceFilter(namespaces []string) predicate.Predicate {
 	return predicate.NewPredicateFuncs(func(object client.Object) bool {
-		// No filter specified
-		if len(namespaces) == 0 || len(namespaces) == 1 && namespaces[0] == "" {
-			return true
-		}
+		return InNamespaces(namespaces, object.GetNamespace())
+	})
+}
 
-		for _, ns := range namespaces {
-			if ns == object.GetNamespace() {
-				return true
-			}
-		}
-		return false
+// ObjectFilter only lets events of the object with the given namespace and
+// name pass.
+func ObjectFilter(namespace, name string) predicate.Predicate {
+	return predicate.NewPredicateFuncs(func(object client.Object) bool {
+		return object.GetNamespace() == namespace && object.GetName() == name
+	})
+}
+
+// ControllerClassFilter only lets events of snippets of the given controller
+// class pass.
+func ControllerClassFilter(class string) predicate.Predicate {
+	return predicate.NewPredicateFuncs(func(object client.Object) bool {
+		return HasControllerClass(class, object)
 	})
 }
+
+// HasControllerClass checks whether object is a snippet of the given
+// controller class. Objects other than snippets never match.
+func HasControllerClass(class string, object client.Object) bool {
+	snippet, ok := object.(interface {
+		GetSpec() *crdv1beta1.AwsAuthMapSnippetSpec
+	})
+	return ok && snippet.GetSpec().ControllerClass == class
+}
+
+// InNamespaces checks whether namespace is part of the watched namespaces.
+func InNamespaces(namespaces []string, namespace string) bool {
+	// No filter specified
+	if len(namespaces) == 0 || len(namespaces) == 1 && namespaces[0] == "" {
+		return true
+	}
+
+	for _, ns := range namespaces {
+		if ns == namespace {
+			return true
+		}
+	}
+	return false
+}
@@ -4,7 +4,9 @@ import (
 	"testing"
 
 	crdv1beta1 "github.com/inovex/aws-auth-controller/pkg/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 
 	. "github.com/onsi/ginkgo/v2"
@@ -41,4 +43,45 @@ var _ = Context("Predicates", func() {
 			Entry("with multple filter skipped", "anyns", []string{"myns", "otherns"}, false),
 		)
 	})
+
+	Describe("objectFilter", func() {
+		DescribeTable("filter for object", func(namespace, name string, result bool) {
+			obj := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: namespace,
+				},
+			}
+			pred := ObjectFilter("kube-system", "aws-auth")
+			Expect(pred.Update(event.UpdateEvent{ObjectOld: obj, ObjectNew: obj})).To(Equal(result))
+		},
+			Entry("with matching object", "kube-system", "aws-auth", true),
+			Entry("with other name", "kube-system", "coredns", false),
+			Entry("with other namespace", "default", "aws-auth", false),
+		)
+	})
+
+	Describe("controllerClassFilter", func() {
+		DescribeTable("filter for controller class", func(obj client.Object, class string, result bool) {
+			pred := ControllerClassFilter(class)
+			Expect(pred.Create(event.CreateEvent{Object: obj})).To(Equal(result))
+		},
+			Entry("with no class", snippetOfClass(""), "", true),
+			Entry("with matching class", snippetOfClass("internal"), "internal", true),
+			Entry("with other class", snippetOfClass("internal"), "external", false),
+			Entry("with class for default controller", snippetOfClass("internal"), "", false),
+			Entry("with no class for other controller", snippetOfClass(""), "internal", false),
+			Entry("with cluster-scoped snippet", &crdv1beta1.ClusterAwsAuthMapSnippet{
+				Spec: crdv1beta1.AwsAuthMapSnippetSpec{ControllerClass: "internal"},
+			}, "internal", true),
+			Entry("with other object", &corev1.ConfigMap{}, "", false),
+		)
+	})
 })
+
+func snippetOfClass(class string) *crdv1beta1.AwsAuthMapSnippet {
+	return &crdv1beta1.AwsAuthMapSnippet{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo-bar", Namespace: "myns"},
+		Spec:       crdv1beta1.AwsAuthMapSnippetSpec{ControllerClass: class},
+	}
+}